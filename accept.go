@@ -0,0 +1,334 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"golang.org/x/text/language"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// joinedHeader returns all lines of the header field combined into a single comma separated list (RFC 9110, section
+// 5.3), so that clients splitting preferences across multiple header lines are fully taken into account.
+func joinedHeader(r *http.Request, name string) string {
+	return strings.Join(r.Header.Values(name), ",")
+}
+
+// qualityValue is a single element of an Accept-* header together with its weight.
+type qualityValue struct {
+	value   string
+	quality float64
+}
+
+// parseQualityValues parses a comma separated Accept-* header (e.g. `utf-8, iso-8859-1;q=0.5`) into its elements.
+// Values are lowercased and sorted by descending quality; elements with equal quality keep the header order.
+// Elements with a malformed quality value are skipped.
+func parseQualityValues(header string) []qualityValue {
+	var values []qualityValue
+	for _, element := range strings.Split(header, ",") {
+		params := strings.Split(element, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(value) == 0 {
+			continue
+		}
+		quality, valid := 1.0, true
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			quality = q
+		}
+		if valid {
+			values = append(values, qualityValue{value: value, quality: quality})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].quality > values[j].quality
+	})
+	return values
+}
+
+// quality returns the weight the client assigned to value, taking the `*` wildcard into account.
+// Values that are not mentioned at all have a weight of 0.
+func quality(accepted []qualityValue, value string) float64 {
+	value = strings.ToLower(value)
+	wildcard := 0.0
+	for _, a := range accepted {
+		if a.value == value {
+			return a.quality
+		}
+		if a.value == "*" && wildcard == 0 {
+			wildcard = a.quality
+		}
+	}
+	return wildcard
+}
+
+// negotiateToken chooses the offered value with the highest weight in accepted. On equal weights the value offered first wins.
+// Values with a weight of 0 are explicitly not acceptable.
+func negotiateToken(accepted []qualityValue, offered []string) (bool, string) {
+	match, result, best := false, "", 0.0
+	for _, o := range offered {
+		if q := quality(accepted, o); q > best {
+			match, result, best = true, o, q
+		}
+	}
+	return match, result
+}
+
+// identityQuality is the weight of the `identity` coding when the client did not mention it. It is acceptable, but any
+// listed coding is preferred over it.
+const identityQuality = 0.001
+
+// negotiateEncoding works like negotiateToken, but treats the `identity` coding as acceptable unless it is excluded
+// explicitly with `identity;q=0` or `*;q=0` ([IETF RFC 7231, section 5.3.4](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.4)).
+func negotiateEncoding(accepted []qualityValue, offered []string) (bool, string) {
+	match, result, best := false, "", 0.0
+	for _, o := range offered {
+		q := quality(accepted, o)
+		if strings.EqualFold(o, "identity") && !mentioned(accepted, "identity") && !mentioned(accepted, "*") {
+			q = identityQuality
+		}
+		if q > best {
+			match, result, best = true, o, q
+		}
+	}
+	return match, result
+}
+
+// mentioned reports whether the client listed value explicitly, regardless of its weight.
+func mentioned(accepted []qualityValue, value string) bool {
+	for _, a := range accepted {
+		if a.value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaTypeQuality returns the weight of the most specific media range in accepted (`type/subtype` over `type/*` over `*/*`)
+// that includes mediaType.
+func mediaTypeQuality(accepted []qualityValue, mediaType string) float64 {
+	mediaType = strings.ToLower(mediaType)
+	major, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, a := range accepted {
+		s := -1
+		switch a.value {
+		case mediaType:
+			s = 2
+		case major + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = a.quality, s
+		}
+	}
+	return q
+}
+
+// negotiateMediaType chooses the offered media type with the highest weight in accepted. On equal weights the media type
+// offered first wins.
+func negotiateMediaType(accepted []qualityValue, offered []string) (bool, string) {
+	match, result, best := false, "", 0.0
+	for _, o := range offered {
+		if q := mediaTypeQuality(accepted, o); q > best {
+			match, result, best = true, o, q
+		}
+	}
+	return match, result
+}
+
+// parsedHeader is a parsed Accept-Language header.
+type parsedHeader struct {
+	// desired languages sorted by descending quality, those of equal quality in header order (language.ParseAcceptLanguage
+	// sorts stably), which the language matchers use to break ties
+	desired []language.Tag
+	quality []float32
+	// language ranges rejected explicitly with a weight of 0
+	rejected []string
+	// position of `i-default` (RFC 2277) in desired languages it was removed from, -1 if it is not requested
+	defaultIndex int
+	// any language is acceptable (`*` with a weight above 0) and its weight
+	anyLanguage bool
+	anyQuality  float32
+	// the header is not a valid Accept-Language header (e.g. `;;;q=`), desired languages are empty
+	malformed error
+}
+
+// parsedHeadersVar is the name of the variable caching parsed headers within a request, so several langneg matchers
+// evaluating the same request don't parse them repeatedly.
+const parsedHeadersVar = "langneg.parsed_headers"
+
+// parseHeader parses an Accept-Language header value, using the per request cache keyed by the header value.
+// Matchers reading different headers (see HeaderName) thus never share a cached result of a different value.
+func parseHeader(r *http.Request, value string) *parsedHeader {
+	cache, _ := caddyhttp.GetVar(r.Context(), parsedHeadersVar).(map[string]*parsedHeader)
+	if header, ok := cache[value]; ok {
+		return header
+	}
+	header := parseHeaderValue(value)
+	if cache == nil {
+		cache = map[string]*parsedHeader{}
+		caddyhttp.SetVar(r.Context(), parsedHeadersVar, cache)
+	}
+	cache[value] = header
+	return header
+}
+
+// parseHeaderValue parses an Accept-Language header value, see parseHeader.
+func parseHeaderValue(value string) *parsedHeader {
+	header := &parsedHeader{rejected: rejectedLanguages(value), defaultIndex: -1}
+	// language tags are case insensitive, but language.ParseAcceptLanguage fails on e.g. `Q=0.5`
+	header.desired, header.quality, header.malformed = language.ParseAcceptLanguage(strings.ToLower(value))
+	header.dropUnknownLanguages()
+	header.anyQuality = float32(quality(parseQualityValues(value), "*"))
+	if header.anyLanguage = header.anyQuality > 0; header.anyLanguage {
+		header.dropAnyLanguage()
+	}
+	return header
+}
+
+// iDefault is the tag golang.org/x/text parses `i-default` into (en-x-i-default).
+var iDefault = language.Make("i-default")
+
+// dropUnknownLanguages removes tags without a known language, e.g. und-x-i-enochian for the grandfathered tag
+// i-enochian, which can't be mapped to a modern equivalent and would negotiate to language.Und, and `i-default`,
+// which is not English but a request for the default language. Other tags are replaced by their preferred values
+// (see preferredTag).
+func (h *parsedHeader) dropUnknownLanguages() {
+	desired, quality := h.desired[:0], h.quality[:0]
+	for i, tag := range h.desired {
+		if tag == iDefault {
+			if h.defaultIndex < 0 {
+				h.defaultIndex = len(desired)
+			}
+			continue
+		}
+		if _, bc := tag.Base(); bc == language.Exact {
+			desired, quality = append(desired, preferredTag(tag)), append(quality, h.quality[i])
+		}
+	}
+	h.desired, h.quality = desired, quality
+}
+
+// dropAnyLanguage removes the `mul` (multiple languages) tag language.ParseAcceptLanguage turns `*` into, so that
+// it is not negotiated as a language of its own.
+func (h *parsedHeader) dropAnyLanguage() {
+	mul := language.Make("mul")
+	desired, quality := h.desired[:0], h.quality[:0]
+	for i, tag := range h.desired {
+		if tag != mul {
+			desired, quality = append(desired, tag), append(quality, h.quality[i])
+		}
+	}
+	h.desired, h.quality = desired, quality
+}
+
+// matchedQuality returns the weight of the desired language tag was negotiated for (see matchedDesired), otherwise the
+// weight of `*`.
+func (h *parsedHeader) matchedQuality(tag language.Tag) float32 {
+	if i := h.matchedDesired(tag); i >= 0 {
+		return h.quality[i]
+	}
+	return h.anyQuality
+}
+
+// formatQuality formats a weight like in an Accept-Language header, with at least one decimal (e.g. `1.0` or `0.85`).
+func formatQuality(q float32) string {
+	value := strconv.FormatFloat(float64(q), 'f', -1, 32)
+	if !strings.Contains(value, ".") {
+		value += ".0"
+	}
+	return value
+}
+
+// matchedDesired returns the position of the desired language tag was negotiated for: the one equal to tag, otherwise
+// the most preferred one with the base language of tag (e.g. en-GB for en) or understanding it (see
+// language.Comprehends), -1 if tag was negotiated for `*`.
+func (h *parsedHeader) matchedDesired(tag language.Tag) int {
+	for _, equal := range []func(d language.Tag) bool{
+		func(d language.Tag) bool { return d == tag },
+		func(d language.Tag) bool { return sameBase(d, tag) },
+		func(d language.Tag) bool { return language.Comprehends(d, tag) > language.No },
+	} {
+		for i, d := range h.desired {
+			if equal(d) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// rejectedLanguages returns language ranges the client explicitly refused with a weight of 0 (e.g. `en;q=0`).
+// language.ParseAcceptLanguage silently drops them, which would make them indistinguishable from not mentioned ones.
+func rejectedLanguages(header string) []string {
+	var rejected []string
+	for _, qv := range parseQualityValues(header) {
+		if qv.quality == 0 && qv.value != "*" {
+			rejected = append(rejected, qv.value)
+		}
+	}
+	return rejected
+}
+
+// isRejected reports whether one of the rejected language ranges includes tag.
+func isRejected(rejected []string, tag language.Tag) bool {
+	for _, r := range rejected {
+		if rangeIncludes(r, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeIncludes reports whether the lowercase language range includes tag, e.g. `en` includes `en-US`, but `en-us`
+// doesn't include `en` ([IETF RFC 4647, section 3.3.1](https://datatracker.ietf.org/doc/html/rfc4647#section-3.3.1)).
+func rangeIncludes(langRange string, tag language.Tag) bool {
+	t := strings.ToLower(tag.String())
+	return t == langRange || strings.HasPrefix(t, langRange+"-")
+}
+
+// basicFilter is a language.Matcher doing basic filtering of RFC 4647 instead of CLDR based best match: the first
+// supported tag included in the most preferred desired range matches exactly, otherwise the first supported tag
+// is returned with no confidence.
+type basicFilter []language.Tag
+
+func (f basicFilter) Match(desired ...language.Tag) (language.Tag, int, language.Confidence) {
+	for _, d := range desired {
+		langRange := strings.ToLower(d.String())
+		for i, t := range f {
+			if !t.IsRoot() && rangeIncludes(langRange, t) {
+				return t, i, language.Exact
+			}
+		}
+	}
+	if len(f) == 0 {
+		return language.Und, 0, language.No
+	}
+	return f[0], 0, language.No
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+func init() {
+	caddy.RegisterModule(&AdminAPI{})
+}
+
+// AdminAPI is a read-only admin endpoint listing the langneg matchers of the running config (GET /langneg/matchers),
+// with their configs and the languages currently available for negotiation (see FilesRoot). Caddy loads it together
+// with the admin API, it needs no configuration.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (*AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.langneg",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the routes of the admin endpoint.
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{{Pattern: "/langneg/matchers", Handler: caddy.AdminHandlerFunc(a.serveMatchers)}}
+}
+
+// matcherInfo describes a provisioned matcher in responses of the admin endpoint.
+type matcherInfo struct {
+	Config    Config   `json:"config"`
+	Available []string `json:"available_languages"`
+}
+
+func (a *AdminAPI) serveMatchers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	provisioned.Lock()
+	infos := make([]matcherInfo, 0, len(provisioned.matchers))
+	for _, m := range provisioned.matchers {
+		info := matcherInfo{Config: m.Config, Available: []string{}}
+		for _, tag := range m.offered().tags[1:] {
+			info.Available = append(info.Available, tag.String())
+		}
+		infos = append(infos, info)
+	}
+	provisioned.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(infos)
+}
+
+// provisioned holds the matchers between Provision and Cleanup, in the order they were provisioned.
+var provisioned struct {
+	sync.Mutex
+	matchers []*Matcher
+}
+
+// register adds m to the matchers listed by the admin endpoint.
+func (m *Matcher) register() {
+	provisioned.Lock()
+	defer provisioned.Unlock()
+	provisioned.matchers = append(provisioned.matchers, m)
+}
+
+// unregister removes m from the matchers listed by the admin endpoint.
+func (m *Matcher) unregister() {
+	provisioned.Lock()
+	defer provisioned.Unlock()
+	provisioned.matchers = slices.DeleteFunc(provisioned.matchers, func(p *Matcher) bool { return p == m })
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
@@ -0,0 +1,86 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"reflect"
+)
+
+func init() {
+	caddy.RegisterModule(&App{})
+	httpcaddyfile.RegisterGlobalOption("langneg", parseApp)
+}
+
+// App holds defaults shared by all langneg matchers, so options repeated across routes (e.g. VarLanguage and
+// FallbackValue) are configured once. Matchers use a default for every option they leave unset.
+type App struct {
+	// Options used by matchers not setting them. Default: Empty config
+	Defaults Config `json:"defaults,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "langneg",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// parseApp configures the `langneg` global option, which takes the options of the langneg matcher as defaults.
+func parseApp(d *caddyfile.Dispenser, _ any) (any, error) {
+	app := &App{}
+	if err := app.Defaults.UnmarshalFromCaddy(d); err != nil {
+		return nil, err
+	}
+	return httpcaddyfile.App{
+		Name:  "langneg",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// Start implements caddy.App, there is nothing to run.
+func (*App) Start() error {
+	return nil
+}
+
+// Stop implements caddy.App.
+func (*App) Stop() error {
+	return nil
+}
+
+// mergeDefaults sets all options of c which are unset (zero values, nil for booleans) to those of defaults. Lists are copied, as
+// Provision modifies them.
+func (c *Config) mergeDefaults(defaults *Config) {
+	value, defaultValue := reflect.ValueOf(c).Elem(), reflect.ValueOf(defaults).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field, defaultField := value.Field(i), defaultValue.Field(i)
+		if !field.IsZero() || defaultField.IsZero() {
+			continue
+		}
+		if defaultField.Kind() == reflect.Slice {
+			defaultField = reflect.AppendSlice(reflect.MakeSlice(defaultField.Type(), 0, defaultField.Len()), defaultField)
+		}
+		field.Set(defaultField)
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.App = (*App)(nil)
+)
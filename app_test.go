@@ -0,0 +1,68 @@
+package langnegmatcher
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestMergeDefaults(t *testing.T) {
+	var defaults Config
+	if err := defaults.UnmarshalFromCaddy(caddyfile.NewTestDispenser("langneg {\nfull_locale true\nstore_confidence true\nvar_language lang\n}")); err != nil {
+		t.Fatal(err)
+	}
+	var parsed Config
+	if err := parsed.UnmarshalFromCaddy(caddyfile.NewTestDispenser("langneg {\nmatch_languages en-US de-DE\nfull_locale false\n}")); err != nil {
+		t.Fatal(err)
+	}
+	// configs reach the matcher as JSON, the explicit false must survive the adaptation
+	b, err := json.Marshal(&Matcher{Config: parsed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Matcher
+	if err := caddy.StrictUnmarshalJSON(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.mergeDefaults(&defaults)
+	if m.Config.FullLocale == nil || *m.Config.FullLocale {
+		t.Errorf("full_locale = %v, want false", m.Config.FullLocale)
+	}
+	if !enabled(m.Config.StoreConfidence) {
+		t.Error("store_confidence not taken from defaults")
+	}
+	if m.Config.VarLanguage != "lang" {
+		t.Errorf("var_language = %q, want lang", m.Config.VarLanguage)
+	}
+
+	provision(t, &m)
+	matched, vars := match(&m, "", acceptLanguage("de-DE"))
+	if !matched || vars["langneg_lang"] != "de" {
+		t.Errorf("match = %v, %v, want true, de", matched, vars["langneg_lang"])
+	}
+}
+
+func TestMergeDefaultsZeroValues(t *testing.T) {
+	var defaults Config
+	if err := defaults.UnmarshalFromCaddy(caddyfile.NewTestDispenser("langneg {\nvar_prefix site_\nfallback_value en\ncache_size 100\ntop_n 2\nsubdomain_index 1\nfallback_map {\npt-BR pt\n}\n}")); err != nil {
+		t.Fatal(err)
+	}
+	var c Config
+	if err := c.UnmarshalFromCaddy(caddyfile.NewTestDispenser("langneg {\nvar_prefix \"\"\nfallback_value \"\"\ncache_size 0\ntop_n 0\nsubdomain_index 0\n}")); err != nil {
+		t.Fatal(err)
+	}
+	c.mergeDefaults(&defaults)
+	// options held by pointers are overridden back to their zero value
+	if c.VarPrefix == nil || *c.VarPrefix != "" {
+		t.Errorf("var_prefix = %v, want empty", c.VarPrefix)
+	}
+	if c.SubdomainIndex == nil || *c.SubdomainIndex != 0 {
+		t.Errorf("subdomain_index = %v, want 0", c.SubdomainIndex)
+	}
+	// zero values of the others mean unset, so the defaults apply (see README)
+	if c.FallbackValue != "en" || c.CacheSize != 100 || c.TopN != 2 || c.FallbackMap["pt-BR"] != "pt" {
+		t.Errorf("fallback_value, cache_size, top_n, fallback_map = %q, %d, %d, %v, want the defaults", c.FallbackValue, c.CacheSize, c.TopN, c.FallbackMap)
+	}
+}
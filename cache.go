@@ -0,0 +1,83 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"container/list"
+	"golang.org/x/text/language"
+	"sync"
+)
+
+// headerResult is the outcome of negotiating an Accept-Language header (see Matcher.matchHeader).
+type headerResult struct {
+	tag        language.Tag
+	index      int
+	confidence language.Confidence
+	reason     string
+}
+
+// cacheEntry is an element of resultCache. Results are only valid for the offer they were negotiated with, which
+// changes when FilesRoot is refreshed.
+type cacheEntry struct {
+	header string
+	offer  *offer
+	result headerResult
+}
+
+// resultCache is a least recently used cache of negotiated results by header value, shared by all requests of a
+// Matcher.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{size: size, entries: make(map[string]*list.Element, size), order: list.New()}
+}
+
+// get returns the cached result of header negotiated with o.
+func (c *resultCache) get(header string, o *offer) (headerResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[header]
+	if !ok {
+		return headerResult{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if entry.offer != o {
+		return headerResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.result, true
+}
+
+// put caches the result of header negotiated with o, evicting the least recently used result if the cache is full.
+func (c *resultCache) put(header string, o *offer, result headerResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[header]; ok {
+		element.Value = &cacheEntry{header: header, offer: o, result: result}
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).header)
+	}
+	c.entries[header] = c.order.PushFront(&cacheEntry{header: header, offer: o, result: result})
+}
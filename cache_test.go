@@ -0,0 +1,75 @@
+package langnegmatcher
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"golang.org/x/text/language"
+)
+
+func TestResultCache(t *testing.T) {
+	o, fresh := &offer{}, &offer{}
+	c := newResultCache(2)
+	c.put("en", o, headerResult{tag: language.English})
+	c.put("de", o, headerResult{tag: language.German})
+	if _, ok := c.get("en", o); !ok {
+		t.Fatal("en not cached")
+	}
+	// de is the least recently used one now
+	c.put("fr", o, headerResult{tag: language.French})
+	if _, ok := c.get("de", o); ok {
+		t.Error("de not evicted")
+	}
+	for _, header := range []string{"en", "fr"} {
+		if _, ok := c.get(header, o); !ok {
+			t.Errorf("%s evicted", header)
+		}
+	}
+	if _, ok := c.get("en", fresh); ok {
+		t.Error("result of another offer returned")
+	}
+	c.put("en", fresh, headerResult{tag: language.BritishEnglish})
+	if result, ok := c.get("en", fresh); !ok || result.tag != language.BritishEnglish {
+		t.Errorf("en = %v, %v, want replaced result", result.tag, ok)
+	}
+}
+
+func TestCachedMatch(t *testing.T) {
+	m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\ncache_size 1\n}")
+	for i := 0; i < 3; i++ {
+		// alternating headers evict each other
+		runMatches(t, m, map[string]string{"de, en;q=0.5": "de", "en-GB": "en"})
+	}
+}
+
+// BenchmarkCache compares cache hits and misses of a typical Accept-Language header to negotiation without cache.
+func BenchmarkCache(b *testing.B) {
+	const header = "en-US,en;q=0.9,de-DE;q=0.8,de;q=0.7"
+	for _, bc := range []struct {
+		name    string
+		size    string
+		headers []string
+	}{
+		{"uncached", "0", []string{header}},
+		{"hit", "16", []string{header}},
+		// two headers evicting each other
+		{"miss", "1", []string{header, "de-DE,de;q=0.9,en-US;q=0.8,en;q=0.7"}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := newMatcher(b, "langneg {\nmatch_languages en de fr es it pt-BR\nvar_language lang\nfull_locale true\ncache_size "+bc.size+"\n}")
+			requests := make([]*http.Request, len(bc.headers))
+			for i, h := range bc.headers {
+				requests[i], _ = newRequest("", acceptLanguage(h))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// fresh variables for every request, as they hold the parsed header
+				r := requests[i%len(requests)]
+				m.Match(r.WithContext(context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{})))
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentLanguage writes the `Content-Language` response header with the result of language negotiation.
+// Matchers run before handlers and cannot modify the response, so this handler reads the variable set by
+// the langneg matcher instead.
+type ContentLanguage struct {
+	varSource
+
+	// Indicator to overwrite `Content-Language` header already set upstream. Default: false
+	Force bool `json:"force,omitempty"`
+	// Indicator to send the negotiated language in a `Content-Language` trailer (e.g. for streaming responses) instead of the header. Clients not supporting trailers get neither. Default: false
+	Trailer bool `json:"trailer,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(&ContentLanguage{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_content_language", parseContentLanguage)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_content_language", httpcaddyfile.Before, "header")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*ContentLanguage) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_content_language",
+		New: func() caddy.Module { return new(ContentLanguage) },
+	}
+}
+
+func parseContentLanguage(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	cl := &ContentLanguage{}
+	err := cl.UnmarshalCaddyfile(h.Dispenser)
+	return cl, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (cl *ContentLanguage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "force":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				cl.Force = boolVal
+			case "trailer":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				cl.Trailer = boolVal
+			default:
+				if ok, err := cl.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_content_language option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (cl *ContentLanguage) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	lang := cl.negotiated(r)
+	if len(lang) == 0 {
+		return next.ServeHTTP(w, r)
+	}
+	if cl.Trailer {
+		if !supportsTrailers(r) {
+			return next.ServeHTTP(w, r)
+		}
+		// announced before the header is written, set after the body
+		w.Header().Add("Trailer", "Content-Language")
+		err := next.ServeHTTP(w, r)
+		w.Header().Set("Content-Language", lang)
+		return err
+	}
+	return next.ServeHTTP(&headerWriter{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		modify: func(header http.Header) {
+			if cl.Force || len(header.Get("Content-Language")) == 0 {
+				header.Set("Content-Language", lang)
+			}
+		},
+	}, r)
+}
+
+// supportsTrailers returns true if trailers reach the client: always with HTTP/2 and later, with HTTP/1.1 only if
+// the client announces it accepts them (`TE: trailers`).
+func supportsTrailers(r *http.Request) bool {
+	if r.ProtoAtLeast(2, 0) {
+		return true
+	}
+	if !r.ProtoAtLeast(1, 1) {
+		return false
+	}
+	for _, te := range strings.Split(joinedHeader(r, "TE"), ",") {
+		if name, _, _ := strings.Cut(te, ";"); strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+	return false
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*ContentLanguage)(nil)
+	_ caddyfile.Unmarshaler       = (*ContentLanguage)(nil)
+)
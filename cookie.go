@@ -0,0 +1,169 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Cookie persists the result of language negotiation in a cookie, so subsequent requests can skip negotiation
+// by configuring the same cookie in the langneg matcher (`cookie_name`).
+type Cookie struct {
+	varSource
+
+	// Name of the cookie. Default: ""
+	CookieName string `json:"cookie_name,omitempty"`
+	// Max-Age attribute of the cookie in seconds. Default: 0 (session cookie)
+	MaxAge int `json:"max_age,omitempty"`
+	// Path attribute of the cookie. Default: "/"
+	Path string `json:"path,omitempty"`
+	// SameSite attribute of the cookie (lax, strict or none). Default: "" (not set)
+	SameSite string `json:"same_site,omitempty"`
+	// Indicator to set the Secure attribute of the cookie. Default: false
+	Secure bool `json:"secure,omitempty"`
+}
+
+// sameSiteModes maps values of SameSite to http.SameSite.
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+func init() {
+	caddy.RegisterModule(&Cookie{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_cookie", parseCookie)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_cookie", httpcaddyfile.Before, "header")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Cookie) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_cookie",
+		New: func() caddy.Module { return new(Cookie) },
+	}
+}
+
+func parseCookie(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	c := &Cookie{}
+	err := c.UnmarshalCaddyfile(h.Dispenser)
+	return c, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (c *Cookie) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "cookie_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.CookieName = d.Val()
+			case "max_age":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				c.MaxAge = intVal
+			case "path":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.Path = d.Val()
+			case "same_site":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.SameSite = d.Val()
+			case "secure":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Secure = boolVal
+			default:
+				if ok, err := c.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_cookie option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Provision sets up the module.
+func (c *Cookie) Provision(_ caddy.Context) error {
+	if len(c.Path) == 0 {
+		c.Path = "/"
+	}
+	return nil
+}
+
+// Validate validates that the module has a usable config.
+func (c *Cookie) Validate() error {
+	if len(c.CookieName) == 0 {
+		return errors.New("cookie_name is required")
+	}
+	if _, ok := sameSiteModes[strings.ToLower(c.SameSite)]; !ok {
+		return fmt.Errorf("same_site must be one of lax, strict or none, got %q", c.SameSite)
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (c *Cookie) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	lang := c.negotiated(r)
+	if len(lang) == 0 {
+		return next.ServeHTTP(w, r)
+	}
+	if current, err := r.Cookie(c.CookieName); err == nil && current.Value == lang {
+		return next.ServeHTTP(w, r)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    lang,
+		Path:     c.Path,
+		MaxAge:   c.MaxAge,
+		SameSite: sameSiteModes[strings.ToLower(c.SameSite)],
+		Secure:   c.Secure,
+	})
+	return next.ServeHTTP(w, r)
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Cookie)(nil)
+	_ caddyfile.Unmarshaler       = (*Cookie)(nil)
+	_ caddy.Provisioner           = (*Cookie)(nil)
+	_ caddy.Validator             = (*Cookie)(nil)
+)
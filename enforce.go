@@ -0,0 +1,93 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"strings"
+)
+
+// Enforce responds with `406 Not Acceptable` when language negotiation found no acceptable language (see
+// varSource.negotiated). Requests with a negotiated language are passed to the next handler.
+type Enforce struct {
+	varSource
+
+	// Available languages listed in the body of the 406 response. Default: Empty list (no body)
+	Languages []string `json:"languages,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(&Enforce{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_enforce", parseEnforce)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_enforce", httpcaddyfile.Before, "redir")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Enforce) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_enforce",
+		New: func() caddy.Module { return new(Enforce) },
+	}
+}
+
+func parseEnforce(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	e := &Enforce{}
+	err := e.UnmarshalCaddyfile(h.Dispenser)
+	return e, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (e *Enforce) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "languages":
+				e.Languages = append(e.Languages, d.RemainingArgs()...)
+			default:
+				if ok, err := e.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_enforce option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (e *Enforce) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if len(e.negotiated(r)) > 0 {
+		return next.ServeHTTP(w, r)
+	}
+	if len(e.Languages) == 0 {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotAcceptable)
+	_, err := w.Write([]byte("Available languages: " + strings.Join(e.Languages, ", ") + "\n"))
+	return err
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Enforce)(nil)
+	_ caddyfile.Unmarshaler       = (*Enforce)(nil)
+)
@@ -0,0 +1,66 @@
+package langnegmatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestEnforce(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		header string
+		status int
+	}{
+		{"matched", "", "de", http.StatusOK},
+		{"no match", "", "ja", http.StatusNotAcceptable},
+		{"no header", "", "", http.StatusNotAcceptable},
+		{"fallback", "fallback_value en", "ja", http.StatusOK},
+		{"unmatched value", "unmatched_value unsupported", "ja", http.StatusNotAcceptable},
+		{"unmatched value without header", "unmatched_value unsupported", "", http.StatusNotAcceptable},
+		{"unmatched value and match", "unmatched_value unsupported", "de", http.StatusOK},
+		{"always match", "always_match true", "ja", http.StatusOK},
+		{"forced", "force_language ja", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\n"+tc.config+"\n}")
+			var headers map[string]string
+			if tc.header != "" {
+				headers = acceptLanguage(tc.header)
+			}
+			r, _ := newRequest("", headers)
+			m.Match(r)
+			if got := serveEnforce(t, r); got != tc.status {
+				t.Errorf("status = %d, want %d", got, tc.status)
+			}
+		})
+	}
+}
+
+func TestEnforceWithoutMatcher(t *testing.T) {
+	r, _ := newRequest("", nil)
+	if got := serveEnforce(t, r); got != http.StatusNotAcceptable {
+		t.Errorf("status without variable = %d, want %d", got, http.StatusNotAcceptable)
+	}
+	caddyhttp.SetVar(r.Context(), "langneg_lang", "de")
+	if got := serveEnforce(t, r); got != http.StatusOK {
+		t.Errorf("status with variable = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func serveEnforce(t *testing.T, r *http.Request) int {
+	t.Helper()
+	e := &Enforce{varSource: varSource{VarLanguage: "lang"}}
+	w := httptest.NewRecorder()
+	err := e.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+	"os"
+	"strings"
+	"time"
+)
+
+// offer is a set of languages available for negotiation together with their matcher.
+type offer struct {
+	matcher language.Matcher
+	// offered languages in matcher order (starting with language.Und) and their positions in MatchLanguages
+	tags      []language.Tag
+	positions []int
+}
+
+// offered returns the languages currently available for negotiation. Unless FilesRoot is set, these are all offered
+// languages. Otherwise, they are replaced by refreshFiles every FilesRefresh.
+func (m *Matcher) offered() *offer {
+	return m.available.Load()
+}
+
+// refreshFiles rescans FilesRoot every FilesRefresh until stop is closed (see Cleanup).
+func (m *Matcher) refreshFiles(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(m.Config.FilesRefresh))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fresh, err := m.scanOffer()
+			if err != nil {
+				// keep the languages of the last successful scan
+				m.logger.Error("refreshing files_root", zap.String("files_root", m.Config.FilesRoot), zap.Error(err))
+				continue
+			}
+			m.available.Store(fresh)
+		}
+	}
+}
+
+// scanOffer returns the offered languages having a translation file (or directory) in FilesRoot.
+func (m *Matcher) scanOffer() (*offer, error) {
+	files, err := scanFiles(m.Config.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+	o := &offer{tags: []language.Tag{language.Und}, positions: []int{-1}}
+	var missing []string
+	for i := 1; i < len(m.tags); i++ {
+		l := m.Config.MatchLanguages[m.positions[i]]
+		if files[strings.ToLower(l)] || files[strings.ToLower(m.tags[i].String())] {
+			o.tags = append(o.tags, m.tags[i])
+			o.positions = append(o.positions, m.positions[i])
+		} else {
+			missing = append(missing, l)
+		}
+	}
+	if len(missing) == 0 {
+		o.matcher = m.LanguageMatcher
+		return o, nil
+	}
+	m.logger.Debug("languages without translation files", zap.String("files_root", m.Config.FilesRoot), zap.Strings("missing", missing))
+	o.matcher = m.newMatcher(o.tags)
+	return o, nil
+}
+
+// scanFiles returns the names of entries in dir without extensions, lowercased and with `_` replaced by `-` (e.g.
+// `pt-br` for `pt_BR.json`), so that they can be compared with language tags.
+func scanFiles(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry.Name(), ".")
+		files[strings.ReplaceAll(strings.ToLower(name), "_", "-")] = true
+	}
+	return files, nil
+}
+
+// readLanguages returns the languages listed in file, skipping blank lines and comments (from `#` to the end of line).
+// A line may list several languages separated by spaces.
+func readLanguages(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var languages []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		languages = append(languages, strings.Fields(line)...)
+	}
+	return languages, nil
+}
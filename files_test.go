@@ -0,0 +1,79 @@
+package langnegmatcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScanFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"en.json", "pt_BR.json", "DE"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := newMatcher(t, "langneg {\nmatch_languages en de pt-BR fr\nvar_language lang\nfull_locale true\nfiles_root "+dir+"\n}")
+	runMatches(t, m, map[string]string{"de": "de", "pt-BR": "pt-BR", "fr, en;q=0.5": "en"})
+}
+
+// runMatches checks the language negotiated by m for each Accept-Language header in want.
+func runMatches(t *testing.T, m *Matcher, want map[string]string) {
+	t.Helper()
+	for header, lang := range want {
+		if _, vars := match(m, "", acceptLanguage(header)); vars["langneg_lang"] != lang {
+			t.Errorf("%s: langneg_lang = %v, want %s", header, vars["langneg_lang"], lang)
+		}
+	}
+}
+
+// TestConcurrentMatch calls Match from many goroutines while files_refresh replaces the available languages, to be
+// run with -race.
+func TestConcurrentMatch(t *testing.T) {
+	dir := t.TempDir()
+	de := filepath.Join(dir, "de.json")
+	if err := os.WriteFile(filepath.Join(dir, "en.json"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\nfiles_root "+dir+"\nfiles_refresh 1ms\ncache_size 4\nstore_confidence true\n}")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// adds and removes the German translation under the running matcher
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if i%2 == 0 {
+				_ = os.WriteFile(de, nil, 0o644)
+			} else {
+				_ = os.Remove(de)
+			}
+		}
+	}()
+	headers := []string{"de", "en", "de-AT, en;q=0.5", "fr"}
+	var matchers sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		matchers.Add(1)
+		go func() {
+			defer matchers.Done()
+			for i := 0; i < 200; i++ {
+				header := headers[(g+i)%len(headers)]
+				_, vars := match(m, "", acceptLanguage(header))
+				if lang := vars["langneg_lang"]; lang != nil && lang != "de" && lang != "en" {
+					t.Errorf("%s: langneg_lang = %v", header, lang)
+				}
+			}
+		}()
+	}
+	matchers.Wait()
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,77 @@
+package langnegmatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// handler is a langneg handler configured from the Caddyfile.
+type handler interface {
+	caddyhttp.MiddlewareHandler
+	caddyfile.Unmarshaler
+}
+
+func TestHandlersWithUnmatchedValue(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler handler
+		config  string
+		// outcome describes what the handler did with the request
+		outcome    func(w *httptest.ResponseRecorder, r *http.Request) string
+		unmatched  string
+		negotiated string
+	}{
+		{"content_language", &ContentLanguage{}, "langneg_content_language {\nvar_language lang\n}",
+			func(w *httptest.ResponseRecorder, r *http.Request) string { return w.Header().Get("Content-Language") }, "", "de"},
+		{"cookie", &Cookie{}, "langneg_cookie {\nvar_language lang\ncookie_name lang\n}",
+			func(w *httptest.ResponseRecorder, r *http.Request) string { return w.Header().Get("Set-Cookie") }, "", "lang=de; Path=/"},
+		{"redirect", &Redirect{}, "langneg_redirect {\nvar_language lang\nto /{lang}/\n}",
+			func(w *httptest.ResponseRecorder, r *http.Request) string { return w.Header().Get("Location") }, "", "/de/"},
+		{"rewrite", &Rewrite{}, "langneg_rewrite {\nvar_language lang\n}",
+			func(w *httptest.ResponseRecorder, r *http.Request) string { return r.URL.Path }, "/page", "/de/page"},
+		{"picker", &Picker{}, "langneg_picker {\nvar_language lang\nlanguages en de\n}",
+			func(w *httptest.ResponseRecorder, r *http.Request) string { return http.StatusText(w.Code) }, "OK", "No Content"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.handler.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tc.config)); err != nil {
+				t.Fatal(err)
+			}
+			if p, ok := tc.handler.(caddy.Provisioner); ok {
+				if err := p.Provision(caddy.Context{Context: context.Background()}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\nunmatched_value unsupported\n}")
+			serve := func(header string) string {
+				r, _ := newRequest("/page", acceptLanguage(header))
+				m.Match(r)
+				w := httptest.NewRecorder()
+				var passed *http.Request
+				err := tc.handler.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+					passed = r
+					w.WriteHeader(http.StatusNoContent)
+					return nil
+				}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if passed == nil {
+					passed = r
+				}
+				return tc.outcome(w, passed)
+			}
+			if got := serve("ja"); got != tc.unmatched {
+				t.Errorf("unmatched request = %q, want %q", got, tc.unmatched)
+			}
+			if got := serve("de"); got != tc.negotiated {
+				t.Errorf("negotiated request = %q, want %q", got, tc.negotiated)
+			}
+		})
+	}
+}
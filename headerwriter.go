@@ -0,0 +1,52 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+)
+
+// headerWriter defers modifying response headers until WriteHeader is called,
+// so headers set by later handlers (e.g. reverse_proxy) can be taken into account.
+type headerWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+	modify      func(http.Header)
+	wroteHeader bool
+}
+
+func (hw *headerWriter) WriteHeader(status int) {
+	if hw.wroteHeader {
+		return
+	}
+	// 1xx responses aren't final; just informational
+	if status < 100 || status > 199 {
+		hw.wroteHeader = true
+		hw.modify(hw.ResponseWriterWrapper.Header())
+	}
+	hw.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (hw *headerWriter) Write(d []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriterWrapper.Write(d)
+}
+
+// Interface guards
+var (
+	_ http.ResponseWriter = (*headerWriter)(nil)
+)
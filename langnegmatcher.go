@@ -16,49 +16,586 @@
 package langnegmatcher
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type Config struct {
-	// List of language codes to match against ([IETF RFC 7231, section 5.3.5](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.5)). Default: Empty list
-	MatchLanguages []string
+	// List of language codes to match against ([IETF RFC 7231, section 5.3.5](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.5)), optionally with a weight preferring some of them on ambiguous matches (e.g. `de;q=0.5`). Default: Empty list
+	MatchLanguages []string `json:"match_languages,omitempty"`
 	// Indicator to include closest to full locale (e.g. en-US) or only language (e.g. en for en-US). Default: false
-	FullLocale bool
-	// Variable name (will be prefixed with `lanneg_`) to hold result of language negotiation. Default: ""
-	VarLanguage string
+	FullLocale *bool `json:"full_locale,omitempty"`
+	// Variable name (will be prefixed with VarPrefix) to hold result of language negotiation. Default: ""
+	VarLanguage string `json:"var_language,omitempty"`
 	// Hardcoded value used if matcher do not match any value. VarLanguage will be set with it. Default: ""
-	FallbackValue string
+	FallbackValue string `json:"fallback_value,omitempty"`
+	// List of character sets to match against ([IETF RFC 7231, section 5.3.3](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.3)). Default: Empty list
+	MatchCharsets []string `json:"match_charsets,omitempty"`
+	// List of content codings to match against ([IETF RFC 7231, section 5.3.4](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.4)). Default: Empty list
+	MatchEncodings []string `json:"match_encodings,omitempty"`
+	// List of media types to match against ([IETF RFC 7231, section 5.3.2](https://datatracker.ietf.org/doc/html/rfc7231#section-5.3.2)). Default: Empty list
+	MatchMediaTypes []string `json:"match_media_types,omitempty"`
+	// Name of a cookie holding the language explicitly chosen by the user. It takes precedence over `Accept-Language` header. Default: ""
+	CookieName string `json:"cookie_name,omitempty"`
+	// Name of a query parameter (e.g. `hl` for `?hl=de`) overriding `Accept-Language` header. Cookie still takes precedence over it. Default: ""
+	QueryParam string `json:"query_param,omitempty"`
+	// Indicator to detect language from the first segment of the request path (e.g. de for /de/index.html). Default: false
+	PathPrefix *bool `json:"path_prefix,omitempty"`
+	// Index of the host label (0 = leftmost, e.g. de for de.example.com) to detect language from. Default: nil (disabled)
+	SubdomainIndex *int `json:"subdomain_index,omitempty"`
+	// Indicator to store confidence of language negotiation (no, low, high or exact) in `langneg_<VarLanguage>_confidence`. Default: false
+	StoreConfidence *bool `json:"store_confidence,omitempty"`
+	// Indicator to store position of the matched language in MatchLanguages (starting at 0) in `langneg_<VarLanguage>_index`. Default: false
+	StoreIndex *bool `json:"store_index,omitempty"`
+	// Indicator to accept malformed MatchLanguages (they are turned into best effort tags, often `und`, by language.Make). Default: false
+	LenientTags *bool `json:"lenient_tags,omitempty"`
+	// Ordered list of language codes tried against offered languages if negotiation fails, before FallbackValue is used. Default: Empty list
+	FallbackLanguages []string `json:"fallback_languages,omitempty"`
+	// Prefix of variable names holding results of negotiation. It may be empty to use VarLanguage as-is. Default: nil (`langneg_`)
+	VarPrefix *string `json:"var_prefix,omitempty"`
+	// Namespace isolating the variables of this matcher from those of other matchers: `<var_prefix><namespace>_<var_language>`. Default: ""
+	Namespace string `json:"namespace,omitempty"`
+	// Indicator to store exactly matched region (e.g. US) and script (e.g. Hant) in `langneg_<VarLanguage>_region` and `langneg_<VarLanguage>_script`. Default: false
+	StoreSubtags *bool `json:"store_subtags,omitempty"`
+	// Minimal confidence (low, high or exact) of a negotiated language to count as a match. Default: "" (low)
+	MinConfidence string `json:"min_confidence,omitempty"`
+	// Name of the request header holding language preferences, e.g. X-Forwarded-Accept-Language behind proxies. Default: "" (Accept-Language)
+	HeaderName string `json:"header_name,omitempty"`
+	// Indicator to invert the result of language matching, i.e. match requests for none of the offered languages. Default: false
+	Negate *bool `json:"negate,omitempty"`
+	// Negotiation mode: lookup (CLDR based best match of golang.org/x/text/language) or basic_filtering ([IETF RFC 4647, section 3.3.1](https://datatracker.ietf.org/doc/html/rfc4647#section-3.3.1)). Default: "" (lookup)
+	Mode string `json:"mode,omitempty"`
+	// Indicator to count negotiation outcomes in Prometheus metric `langneg_matches_total`. Default: false
+	Metrics *bool `json:"metrics,omitempty"`
+	// Result of matching when the fallback is used, independent of VarLanguage. Default: nil (true if VarLanguage is set, otherwise the fallback is not used)
+	MatchOnFallback *bool `json:"match_on_fallback,omitempty"`
+	// Offered languages preferred on ambiguous matches, in order. Each of them must be one of MatchLanguages. Default: Empty list (order of MatchLanguages by their weights)
+	Preference []string `json:"preference,omitempty"`
+	// Indicator to store all languages acceptable to the client, sorted by their weight, in `<var_language>_accepted`. Default: false
+	StoreAccepted *bool `json:"store_accepted,omitempty"`
+	// Language used as-is when the request has no language preferences at all (no header and no other source). Default: ""
+	DefaultLanguage string `json:"default_language,omitempty"`
+	// Indicator to fall back to offered languages mutually intelligible with requested ones (e.g. da for nb) if the best match has low or no confidence. Default: false
+	Comprehends *bool `json:"comprehends,omitempty"`
+	// Indicator to store the canonical BCP 47 form (e.g. zh-Hant-TW) of the negotiated language. Set it to false for the legacy format (e.g. zh-TW-Hant). Default: nil (true)
+	Canonicalize *bool `json:"canonicalize,omitempty"`
+	// Indicator that requests without (or with an empty) Accept-Language header (or HeaderName) never match. Default: false
+	RequireHeader *bool `json:"require_header,omitempty"`
+	// Languages served (values) if negotiation fails for a requested language or base language (keys), e.g. `pt-BR` -> `pt`. Consulted before FallbackLanguages and FallbackValue. Default: Empty map
+	FallbackMap map[string]string `json:"fallback_map,omitempty"`
+	// Directory with translation files (e.g. `en.json`, `pt_BR.json`). If set, only offered languages having a file (or directory) named after them are negotiated. Default: ""
+	FilesRoot string `json:"files_root,omitempty"`
+	// Interval of rescanning FilesRoot in the background. Default: 0 (scanning only when the config is loaded)
+	FilesRefresh caddy.Duration `json:"files_refresh,omitempty"`
+	// Case of the region stored by StoreSubtags: upper (e.g. US) or lower (e.g. us). Default: "" (upper)
+	RegionCase string `json:"region_case,omitempty"`
+	// Case of the script stored by StoreSubtags: title (e.g. Hant) or lower (e.g. hant). Default: "" (title)
+	ScriptCase string `json:"script_case,omitempty"`
+	// Strict format of the stored language: lang, lang-region or lang-Script-region. A negotiated language lacking a required subtag doesn't match. Overrides FullLocale. Default: "" (see FullLocale)
+	LocaleFormat string `json:"locale_format,omitempty"`
+	// Indicator to drop the region of a full locale if it is the likely region of the language according to CLDR (e.g. en for en-US, but pt-PT). Default: false
+	SuppressDefaultRegion *bool `json:"suppress_default_region,omitempty"`
+	// Indicator to negotiate only the client's most preferred language, ignoring lower ranked ones (e.g. no match for `ja, en;q=0.5` if only en is offered). Default: false
+	TopPreferenceOnly *bool `json:"top_preference_only,omitempty"`
+	// Indicator that offered macrolanguages (e.g. zh or ar) match their member languages (e.g. yue or arz) if the member language is not offered itself. Default: false
+	Macrolanguage *bool `json:"macrolanguage,omitempty"`
+	// File with more languages offered in addition to MatchLanguages, one per line. Blank lines and comments starting with `#` are ignored. Read when the config is loaded. Default: ""
+	MatchLanguagesFile string `json:"match_languages_file,omitempty"`
+	// Languages of referring hosts (e.g. `example.de` -> `de`), negotiated if the request has no Accept-Language header (or HeaderName) and no other source. Default: Empty map
+	RefererHostMap map[string]string `json:"referer_host_map,omitempty"`
+	// Indicator that a negotiated language without region doesn't match with FullLocale, instead of storing the bare language. Default: false (lenient)
+	FullLocaleStrict *bool `json:"full_locale_strict,omitempty"`
+	// Indicator to store the weight (q-value) the client gave to the negotiated language in `<var_language>_q` if it was negotiated from the header. Default: false
+	StoreQuality *bool `json:"store_quality,omitempty"`
+	// Indicator to match every request with a parseable Accept-Language header (or HeaderName), storing the client's most preferred language if none of MatchLanguages is acceptable. Default: false
+	AlwaysMatch *bool `json:"always_match,omitempty"`
+	// Template of the stored language with the tokens `{base}`, `{script}`, `{region}` and `{tag}` (e.g. `{base}_{region}` for en_US). A `-` or `_` right before an empty token is dropped. Overrides FullLocale. Default: "" (see FullLocale)
+	StoreFormat string `json:"store_format,omitempty"`
+	// Client IP ranges (CIDRs or single IPs) whose requests skip negotiation, e.g. of health checks. Default: Empty list
+	BypassCIDRs []string `json:"bypass_cidrs,omitempty"`
+	// Result of the matcher for requests of BypassCIDRs. Default: nil (true)
+	BypassMatch *bool `json:"bypass_match,omitempty"`
+	// Number of negotiated results cached by Accept-Language header value (or HeaderName), shared across requests. Default: 0 (no cache)
+	CacheSize int `json:"cache_size,omitempty"`
+	// Level of the log entry summarizing each negotiation: debug, info, warn or error. Default: "" (debug)
+	LogLevel string `json:"log_level,omitempty"`
+	// Sources of language preferences in the order they are consulted: cookie, query, path, subdomain, header and referer. The first one yielding an offered language wins, sources not listed are ignored. Default: Empty list (all in this order)
+	Sources []string `json:"sources,omitempty"`
+	// Indicator that a language negotiated from the header only matches if the client listed its base language, e.g. not da for nb (see Comprehends) or any language for `*`. Default: false
+	RequireExplicitBase *bool `json:"require_explicit_base,omitempty"`
+	// Languages that don't match even if negotiated, e.g. `en-IN` while `en` is offered. The requested language is excluded if it has the base language, and the script and region given explicitly, of an excluded one. Default: Empty list
+	Exclude []string `json:"exclude,omitempty"`
+	// Language stored for every request instead of negotiating one, for testing handlers depending on the language (e.g. in staging). Not intended for production. Default: "" (negotiate)
+	ForceLanguage string `json:"force_language,omitempty"`
+	// Indicator to store the name of the negotiated language in `<var_language>_name`, e.g. Deutsch for de, falling back to the stored value if no name is known. Default: false
+	DisplayNames *bool `json:"display_names,omitempty"`
+	// Language of the names stored with DisplayNames. Default: "" (each language in itself)
+	DisplayLocale string `json:"display_locale,omitempty"`
+	// Indicator that a negotiated language only matches if its base language has a two-letter ISO 639-1 code, e.g. not fil or haw. Default: false
+	ISO6391Only *bool `json:"iso639_1_only,omitempty"`
+	// Value stored in the variable if no language matches and no fallback applies, e.g. for a catch-all route rendering an "unsupported language" page. The matcher still returns false. Default: "" (nothing stored)
+	UnmatchedValue string `json:"unmatched_value,omitempty"`
+	// Number of offered languages stored in the variable, comma separated in order of preference, for pages showing several languages at once. The first one is the negotiated language, the others are negotiated for the further languages of the header. Default: 0 (1)
+	TopN int `json:"top_n,omitempty"`
+	// Request header read instead of HeaderName if the request comes from one of TrustedCIDRs and has it, e.g. `X-Original-Accept-Language` set by an edge proxy. Default: "" (none)
+	TrustedHeader string `json:"trusted_header,omitempty"`
+	// IP ranges (CIDR notation) or addresses of proxies trusted to send TrustedHeader, compared with the remote address of the connection. Default: Empty list
+	TrustedCIDRs []string `json:"trusted_cidrs,omitempty"`
+	// Locales actually served (e.g. directories). The negotiated language is replaced by the closest of them, stored as-is, and doesn't match if none is close. Default: Empty list (store the negotiated language)
+	ServingLocales []string `json:"serving_locales,omitempty"`
+	// Separator of subtags in stored full locales (FullLocale, LocaleFormat), `-` or `_` (e.g. en_US for Java or POSIX backends). Default: "" (`-`)
+	LocaleSeparator string `json:"locale_separator,omitempty"`
+	// Indicator to store the default IANA time zone of the negotiated language in `<var_language>_tz` (e.g. Europe/Berlin for de), from TimezoneMap or a built-in table of regions. Default: false
+	StoreTimezone *bool `json:"store_timezone,omitempty"`
+	// Time zones (values) of languages, regions or tags (keys), e.g. `AT` -> `Europe/Vienna`, taking precedence over the built-in table. Regions are given uppercase and languages lowercase (`ES` is Spain, `es` Spanish). Setting it enables StoreTimezone. Default: Empty map
+	TimezoneMap map[string]string `json:"timezone_map,omitempty"`
 }
 
 func (c *Config) UnmarshalFromCaddy(d *caddyfile.Dispenser) error {
 	for d.Next() {
+		if err := c.unmarshalInline(d); err != nil {
+			return err
+		}
 		for nesting := d.Nesting(); d.NextBlock(nesting); {
 			switch d.Val() {
 			case "match_languages":
 				c.MatchLanguages = append(c.MatchLanguages, d.RemainingArgs()...)
 			case "full_locale":
-				d.Next()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
 				val := d.Val()
 				boolVal, err := strconv.ParseBool(val)
 				if err != nil {
 					return err
 				}
-				c.FullLocale = boolVal
+				c.FullLocale = &boolVal
 			case "var_language":
-				d.Next()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
 				c.VarLanguage = d.Val()
 			case "fallback_value":
-				d.Next()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
 				c.FallbackValue = d.Val()
+			case "charset":
+				c.MatchCharsets = append(c.MatchCharsets, d.RemainingArgs()...)
+			case "encoding":
+				c.MatchEncodings = append(c.MatchEncodings, d.RemainingArgs()...)
+			case "media_type":
+				c.MatchMediaTypes = append(c.MatchMediaTypes, d.RemainingArgs()...)
+			case "cookie_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.CookieName = d.Val()
+			case "query_param":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.QueryParam = d.Val()
+			case "path_prefix":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.PathPrefix = &boolVal
+			case "subdomain_index":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				c.SubdomainIndex = &intVal
+			case "store_confidence":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreConfidence = &boolVal
+			case "store_index":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreIndex = &boolVal
+			case "lenient_tags":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.LenientTags = &boolVal
+			case "fallback_languages":
+				c.FallbackLanguages = append(c.FallbackLanguages, d.RemainingArgs()...)
+			case "var_prefix":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				prefix := d.Val()
+				c.VarPrefix = &prefix
+			case "namespace":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.Namespace = d.Val()
+			case "store_subtags":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreSubtags = &boolVal
+			case "min_confidence":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.MinConfidence = d.Val()
+			case "header_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.HeaderName = d.Val()
+			case "negate":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Negate = &boolVal
+			case "mode":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.Mode = d.Val()
+			case "metrics":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Metrics = &boolVal
+			case "match_on_fallback":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.MatchOnFallback = &boolVal
+			case "preference":
+				c.Preference = append(c.Preference, d.RemainingArgs()...)
+			case "store_accepted":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreAccepted = &boolVal
+			case "default_language":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.DefaultLanguage = d.Val()
+			case "comprehends":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Comprehends = &boolVal
+			case "canonicalize":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Canonicalize = &boolVal
+			case "require_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.RequireHeader = &boolVal
+			case "fallback_map":
+				if c.FallbackMap == nil {
+					c.FallbackMap = map[string]string{}
+				}
+				for mapNesting := d.Nesting(); d.NextBlock(mapNesting); {
+					requested := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					c.FallbackMap[requested] = d.Val()
+				}
+			case "files_root":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.FilesRoot = d.Val()
+			case "files_refresh":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return err
+				}
+				c.FilesRefresh = caddy.Duration(dur)
+			case "region_case":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.RegionCase = d.Val()
+			case "script_case":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.ScriptCase = d.Val()
+			case "locale_format":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.LocaleFormat = d.Val()
+			case "suppress_default_region":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.SuppressDefaultRegion = &boolVal
+			case "top_preference_only":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.TopPreferenceOnly = &boolVal
+			case "macrolanguage":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.Macrolanguage = &boolVal
+			case "match_languages_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.MatchLanguagesFile = d.Val()
+			case "referer_host_map":
+				if c.RefererHostMap == nil {
+					c.RefererHostMap = map[string]string{}
+				}
+				for mapNesting := d.Nesting(); d.NextBlock(mapNesting); {
+					host := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					c.RefererHostMap[host] = d.Val()
+				}
+			case "full_locale_strict":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.FullLocaleStrict = &boolVal
+			case "store_quality":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreQuality = &boolVal
+			case "always_match":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.AlwaysMatch = &boolVal
+			case "store_format":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.StoreFormat = d.Val()
+			case "bypass_cidrs":
+				c.BypassCIDRs = append(c.BypassCIDRs, d.RemainingArgs()...)
+			case "bypass_match":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.BypassMatch = &boolVal
+			case "cache_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				c.CacheSize = intVal
+			case "log_level":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.LogLevel = d.Val()
+			case "sources":
+				c.Sources = append(c.Sources, d.RemainingArgs()...)
+			case "require_explicit_base":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.RequireExplicitBase = &boolVal
+			case "exclude":
+				c.Exclude = append(c.Exclude, d.RemainingArgs()...)
+			case "force_language":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.ForceLanguage = d.Val()
+			case "display_names":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.DisplayNames = &boolVal
+			case "display_locale":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.DisplayLocale = d.Val()
+			case "iso639_1_only":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.ISO6391Only = &boolVal
+			case "unmatched_value":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.UnmatchedValue = d.Val()
+			case "top_n":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				c.TopN = intVal
+			case "trusted_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.TrustedHeader = d.Val()
+			case "trusted_cidrs":
+				c.TrustedCIDRs = append(c.TrustedCIDRs, d.RemainingArgs()...)
+			case "serving_locales":
+				c.ServingLocales = append(c.ServingLocales, d.RemainingArgs()...)
+			case "locale_separator":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				c.LocaleSeparator = d.Val()
+			case "store_timezone":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				boolVal, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return err
+				}
+				c.StoreTimezone = &boolVal
+			case "timezone_map":
+				if c.TimezoneMap == nil {
+					c.TimezoneMap = map[string]string{}
+				}
+				for mapNesting := d.Nesting(); d.NextBlock(mapNesting); {
+					key := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					c.TimezoneMap[key] = d.Val()
+				}
+			default:
+				return d.Errf("unrecognized langneg option %q", d.Val())
 			}
 		}
 	}
@@ -74,10 +611,49 @@ func (c *Config) UnmarshalFromCaddy(d *caddyfile.Dispenser) error {
 // COMPATIBILITY NOTE: This module is still experimental and is not
 // subject to Caddy's compatibility guarantee.
 type Matcher struct {
-	Config Config
+	Config
 
-	LanguageMatcher language.Matcher
-	logger          *zap.Logger
+	// Caddy calls Match concurrently from many goroutines, so the fields below are set up in Provision and only read
+	// afterwards, except for the atomically replaced available languages. State of a single request (e.g. parsed
+	// headers) is kept in its vars instead.
+	LanguageMatcher language.Matcher `json:"-"`
+	// offered languages in LanguageMatcher order (starting with language.Und) and their positions in MatchLanguages
+	tags      []language.Tag
+	positions []int
+	// position of the `*` wildcard in MatchLanguages, -1 if it is not offered
+	wildcard int
+	// positions of `ll-*` entries in MatchLanguages, offering any region of a base language
+	baseWildcards map[int]bool
+	minConfidence language.Confidence
+	// results by header value, nil without CacheSize
+	cache *resultCache
+	// level of the negotiation summary (see LogLevel)
+	logLevel zapcore.Level
+	// parsed BypassCIDRs and TrustedCIDRs
+	bypassNets  []*net.IPNet
+	trustedNets []*net.IPNet
+	// parsed Exclude
+	excluded []language.Tag
+	// weights of MatchLanguages (e.g. `de;q=0.5`), which are stripped of them
+	qualities []float64
+	// matcher of ServingLocales, nil without them
+	serving language.Matcher
+	// names of languages stored with DisplayNames
+	namer display.Namer
+	// FallbackMap and RefererHostMap with lowercased keys
+	fallbackMap    map[string]string
+	refererHostMap map[string]string
+	// TimezoneMap split by kind of key (see splitTimezoneMap)
+	timezoneTags    map[string]string
+	timezoneRegions map[string]string
+	timezoneBases   map[string]string
+	// VarPrefix with Namespace
+	varPrefix *string
+	// languages available for negotiation (see FilesRoot), replaced by the goroutine refreshing them until stop is closed
+	available  atomic.Pointer[offer]
+	stop       chan struct{}
+	background sync.WaitGroup
+	logger     *zap.Logger
 }
 
 func init() {
@@ -92,95 +668,1484 @@ func (*Matcher) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
+// unmarshalInline parses the shorthand form on the same line as the matcher name, e.g. `langneg en de var lang fallback en`.
+// Arguments before the first keyword are offered languages, so the keywords `var` and `fallback` can't be offered inline.
+func (c *Config) unmarshalInline(d *caddyfile.Dispenser) error {
+	keywords := false
+	for d.NextArg() {
+		switch d.Val() {
+		case "var":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			c.VarLanguage, keywords = d.Val(), true
+		case "fallback":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			c.FallbackValue, keywords = d.Val(), true
+		default:
+			if keywords {
+				return d.Errf("unexpected langneg argument %q, languages must precede var and fallback", d.Val())
+			}
+			c.MatchLanguages = append(c.MatchLanguages, d.Val())
+		}
+	}
+	return nil
+}
+
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 func (m *Matcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	cfg := &Config{}
 	err := cfg.UnmarshalFromCaddy(d)
 	if err != nil {
-		m.logger.Error("error unmarshalling caddy into config", zap.Error(err))
+		// the logger is not available before provisioning, the error is reported by the Caddyfile adapter
 		return err
 	}
 	m.Config = *cfg
 	return nil
 }
 
+// legacyConfig is the JSON shape of the config before it got JSON tags: the Caddyfile adapter nested it under "Config"
+// with Go field names, e.g. {"Config": {"MatchLanguages": ["de", "en"]}}.
+type legacyConfig struct {
+	MatchLanguages []string
+	FullLocale     *bool
+	VarLanguage    string
+	FallbackValue  string
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts the config both flat and in its legacy shape nested under
+// "Config", options given flat win. Unknown fields are rejected, as Caddy does for modules.
+func (m *Matcher) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if legacy, ok := fields["Config"]; ok {
+		var c legacyConfig
+		if err := caddy.StrictUnmarshalJSON(legacy, &c); err != nil {
+			return fmt.Errorf("legacy Config: %v", err)
+		}
+		m.Config.MatchLanguages, m.Config.FullLocale, m.Config.VarLanguage, m.Config.FallbackValue = c.MatchLanguages, c.FullLocale, c.VarLanguage, c.FallbackValue
+		delete(fields, "Config")
+		// the adapter used to emit the exported matcher of the old struct as well
+		delete(fields, "LanguageMatcher")
+		var err error
+		if b, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	// a type without the methods of Matcher, which would recurse
+	type matcher Matcher
+	return caddy.StrictUnmarshalJSON(b, (*matcher)(m))
+}
+
 // Provision sets up the module.
 func (m *Matcher) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
-	var MatchTLanguages []language.Tag
-	MatchTLanguages = append(MatchTLanguages, language.Und)
-	for _, l := range m.Config.MatchLanguages {
-		MatchTLanguages = append(MatchTLanguages, language.Make(l))
+	if app, err := ctx.AppIfConfigured("langneg"); err == nil {
+		m.Config.mergeDefaults(&app.(*App).Defaults)
+	} else if !errors.Is(err, caddy.ErrNotConfigured) {
+		return fmt.Errorf("loading langneg defaults: %v", err)
+	}
+	m.varPrefix = namespaced(m.Config.VarPrefix, m.Config.Namespace)
+	m.qualities = make([]float64, len(m.Config.MatchLanguages))
+	for i, l := range m.Config.MatchLanguages {
+		var err error
+		if m.Config.MatchLanguages[i], m.qualities[i], err = offeredQuality(l); err != nil {
+			return fmt.Errorf("parsing match_languages: %v", err)
+		}
+	}
+	if len(m.Config.MatchLanguagesFile) > 0 {
+		languages, err := readLanguages(m.Config.MatchLanguagesFile)
+		if err != nil {
+			return fmt.Errorf("reading match_languages_file: %v", err)
+		}
+		for _, l := range languages {
+			l, q, err := offeredQuality(l)
+			if err != nil {
+				return fmt.Errorf("parsing match_languages_file: %v", err)
+			}
+			// languages configured inline as well are offered once, at their inline position
+			if !slices.ContainsFunc(m.Config.MatchLanguages, func(c string) bool { return strings.EqualFold(c, l) }) {
+				m.Config.MatchLanguages, m.qualities = append(m.Config.MatchLanguages, l), append(m.qualities, q)
+			}
+		}
+	}
+	m.tags, m.positions, m.wildcard = []language.Tag{language.Und}, []int{-1}, -1
+	m.baseWildcards = map[int]bool{}
+	for _, i := range m.preferenceOrder() {
+		l := m.Config.MatchLanguages[i]
+		if l == "*" {
+			m.wildcard = i
+			continue
+		}
+		if base, ok := strings.CutSuffix(l, "-*"); ok {
+			// matched as the base language, the requested regional variant is used instead
+			m.baseWildcards[i], l = true, base
+		}
+		tag := preferredTag(language.Make(l))
+		if tag.IsRoot() {
+			// und (or a tag accepted by LenientTags that can't be parsed) would be taken for the sentinel of no match
+			continue
+		}
+		m.tags = append(m.tags, tag)
+		m.positions = append(m.positions, i)
+	}
+	m.LanguageMatcher = m.newMatcher(m.tags)
+	available := &offer{matcher: m.LanguageMatcher, tags: m.tags, positions: m.positions}
+	if len(m.Config.FilesRoot) > 0 {
+		var err error
+		if available, err = m.scanOffer(); err != nil {
+			return fmt.Errorf("scanning files_root: %v", err)
+		}
+	}
+	m.available.Store(available)
+	if len(m.Config.FilesRoot) > 0 && m.Config.FilesRefresh > 0 {
+		stop := make(chan struct{})
+		m.stop = stop
+		m.background.Add(1)
+		go func() {
+			defer m.background.Done()
+			m.refreshFiles(stop)
+		}()
+	}
+	m.minConfidence = confidenceLevels[strings.ToLower(m.Config.MinConfidence)]
+	m.fallbackMap = make(map[string]string, len(m.Config.FallbackMap))
+	for requested, served := range m.Config.FallbackMap {
+		m.fallbackMap[strings.ToLower(requested)] = served
+	}
+	if len(m.Config.ForceLanguage) > 0 {
+		m.logger.Warn("force_language is set, languages are not negotiated (not intended for production)", zap.String("language", m.Config.ForceLanguage))
+	}
+	m.logLevel = zapcore.DebugLevel
+	if len(m.Config.LogLevel) > 0 {
+		m.logLevel, _ = zapcore.ParseLevel(strings.ToLower(m.Config.LogLevel))
+	}
+	if m.Config.CacheSize > 0 {
+		m.cache = newResultCache(m.Config.CacheSize)
+	}
+	m.bypassNets = nil
+	for _, cidr := range m.Config.BypassCIDRs {
+		ipNet, err := parseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parsing bypass_cidrs: %v", err)
+		}
+		m.bypassNets = append(m.bypassNets, ipNet)
+	}
+	m.trustedNets = nil
+	for _, cidr := range m.Config.TrustedCIDRs {
+		ipNet, err := parseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parsing trusted_cidrs: %v", err)
+		}
+		m.trustedNets = append(m.trustedNets, ipNet)
+	}
+	m.excluded = nil
+	for _, l := range m.Config.Exclude {
+		tag, err := language.Parse(l)
+		if err != nil {
+			return fmt.Errorf("parsing exclude: %v", err)
+		}
+		m.excluded = append(m.excluded, tag)
+	}
+	m.serving = nil
+	if len(m.Config.ServingLocales) > 0 {
+		tags := make([]language.Tag, len(m.Config.ServingLocales))
+		for i, l := range m.Config.ServingLocales {
+			tag, err := language.Parse(l)
+			if err != nil {
+				return fmt.Errorf("parsing serving_locales: %v", err)
+			}
+			if tag.IsRoot() {
+				// language.Und stands for no match
+				return fmt.Errorf("parsing serving_locales: %q is not a language", l)
+			}
+			tags[i] = tag
+		}
+		m.serving = language.NewMatcher(tags)
+	}
+	m.namer = display.Self
+	if len(m.Config.DisplayLocale) > 0 {
+		m.namer = display.Tags(language.Make(m.Config.DisplayLocale))
+	}
+	m.refererHostMap = make(map[string]string, len(m.Config.RefererHostMap))
+	for host, lang := range m.Config.RefererHostMap {
+		m.refererHostMap[strings.ToLower(host)] = lang
+	}
+	m.splitTimezoneMap()
+	if enabled(m.Config.Metrics) {
+		if err := initMetrics(); err != nil {
+			return fmt.Errorf("registering metrics: %v", err)
+		}
+	}
+	m.register()
+	return nil
+}
+
+// Cleanup stops background goroutines (refreshing FilesRoot) when the config is unloaded and waits until they
+// have finished, so no resources of the old config are used after a reload. It also removes the matcher from the
+// admin endpoint (see AdminAPI). It is safe to call more than once.
+func (m *Matcher) Cleanup() error {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
 	}
-	m.LanguageMatcher = language.NewMatcher(MatchTLanguages)
+	m.background.Wait()
+	m.unregister()
 	return nil
 }
 
+// confidenceLevels maps values of MinConfidence to language.Confidence.
+var confidenceLevels = map[string]language.Confidence{
+	"":      language.Low,
+	"low":   language.Low,
+	"high":  language.High,
+	"exact": language.Exact,
+}
+
+// offeredQuality splits the weight of an offered language off, e.g. `de;q=0.5` into de and 0.5. Languages without a
+// weight have 1.
+func offeredQuality(l string) (string, float64, error) {
+	l, param, found := strings.Cut(l, ";")
+	if !found {
+		return l, 1, nil
+	}
+	key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+	if !strings.EqualFold(strings.TrimSpace(key), "q") {
+		return "", 0, fmt.Errorf("invalid parameter %q of %s, only q is supported", param, l)
+	}
+	q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil || q <= 0 || q > 1 {
+		return "", 0, fmt.Errorf("weight of %s must be above 0 and at most 1, got %q", l, val)
+	}
+	return strings.TrimSpace(l), q, nil
+}
+
+// preferenceOrder returns the positions of MatchLanguages in the order they are passed to the language matcher, which
+// prefers earlier tags on ambiguous matches: languages listed in Preference first, then the remaining ones by
+// descending weight (see offeredQuality), those of equal weight in order.
+func (m *Matcher) preferenceOrder() []int {
+	order := make([]int, 0, len(m.Config.MatchLanguages))
+	used := make([]bool, len(m.Config.MatchLanguages))
+	for _, p := range m.Config.Preference {
+		for i, l := range m.Config.MatchLanguages {
+			if !used[i] && strings.EqualFold(l, p) {
+				order, used[i] = append(order, i), true
+				break
+			}
+		}
+	}
+	remaining := make([]int, 0, len(m.Config.MatchLanguages))
+	for i := range m.Config.MatchLanguages {
+		if !used[i] {
+			remaining = append(remaining, i)
+		}
+	}
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return m.qualities[remaining[i]] > m.qualities[remaining[j]]
+	})
+	return append(order, remaining...)
+}
+
 // Validate validates that the module has a usable config.
 func (m *Matcher) Validate() error {
-	if len(m.Config.MatchLanguages) == 0 && len(m.Config.VarLanguage) > 0 {
-		return errors.New("you cannot specify a variable to store content negotiation results (for languages) if you don't also specify what languages are offered. (Use '*' to work around this constraint.)")
+	if m.Config.Mode != "" && m.Config.Mode != "lookup" && m.Config.Mode != "basic_filtering" {
+		return fmt.Errorf("mode must be lookup or basic_filtering, got %q", m.Config.Mode)
+	}
+	if _, ok := confidenceLevels[strings.ToLower(m.Config.MinConfidence)]; !ok {
+		return fmt.Errorf("min_confidence must be one of low, high or exact, got %q", m.Config.MinConfidence)
+	}
+	if m.Config.RegionCase != "" && m.Config.RegionCase != "upper" && m.Config.RegionCase != "lower" {
+		return fmt.Errorf("region_case must be upper or lower, got %q", m.Config.RegionCase)
+	}
+	if m.Config.ScriptCase != "" && m.Config.ScriptCase != "title" && m.Config.ScriptCase != "lower" {
+		return fmt.Errorf("script_case must be title or lower, got %q", m.Config.ScriptCase)
+	}
+	switch m.Config.LocaleFormat {
+	case "", "lang", "lang-region", "lang-Script-region":
+	default:
+		return fmt.Errorf("locale_format must be lang, lang-region or lang-Script-region, got %q", m.Config.LocaleFormat)
+	}
+	switch strings.ToLower(m.Config.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log_level must be one of debug, info, warn or error, got %q", m.Config.LogLevel)
+	}
+	for _, source := range m.Config.Sources {
+		if !slices.Contains(defaultSources, source) {
+			return fmt.Errorf("sources must be cookie, query, path, subdomain, header or referer, got %q", source)
+		}
+	}
+	if name := varName(m.varPrefix, m.Config.VarLanguage); len(m.Config.VarLanguage) > 0 && !validVarName(name) {
+		return fmt.Errorf("variable name %q of var_prefix, namespace and var_language may only contain letters, digits, underscores and placeholders", name)
+	}
+	if len(m.Config.TrustedHeader) > 0 && len(m.Config.TrustedCIDRs) == 0 {
+		return errors.New("trusted_header requires trusted_cidrs")
+	}
+	if sep := m.Config.LocaleSeparator; sep != "" && sep != "-" && sep != "_" {
+		return fmt.Errorf("locale_separator must be - or _, got %q", sep)
+	}
+	if m.Config.TopN < 0 {
+		return fmt.Errorf("top_n must not be negative, got %d", m.Config.TopN)
+	}
+	if m.Config.CacheSize < 0 {
+		return fmt.Errorf("cache_size must not be negative, got %d", m.Config.CacheSize)
+	}
+	if len(m.Config.StoreFormat) > 0 {
+		if _, ok := m.expandStoreFormat(language.English); !ok {
+			return fmt.Errorf("store_format may only contain the tokens {base}, {script}, {region} and {tag}, got %q", m.Config.StoreFormat)
+		}
+	}
+	offered := len(m.Config.MatchLanguages) + len(m.Config.MatchCharsets) + len(m.Config.MatchEncodings) + len(m.Config.MatchMediaTypes)
+	if len(m.Config.DisplayLocale) > 0 {
+		if _, err := language.Parse(m.Config.DisplayLocale); err != nil {
+			return fmt.Errorf("display_locale must be a language tag, got %q", m.Config.DisplayLocale)
+		}
+	}
+	if len(m.Config.ForceLanguage) > 0 {
+		if tag, err := language.Parse(m.Config.ForceLanguage); err != nil || tag.IsRoot() {
+			return fmt.Errorf("force_language must be a language tag, got %q", m.Config.ForceLanguage)
+		}
+		offered++
+	}
+	if offered == 0 && len(m.Config.VarLanguage) > 0 && len(m.Config.FallbackValue) == 0 {
+		return errors.New("you cannot specify a variable to store content negotiation results if you don't also specify what languages, charsets, encodings or media types are offered or a fallback value. (Use '*' to work around this constraint.)")
+	}
+	for _, p := range m.Config.Preference {
+		if !slices.ContainsFunc(m.Config.MatchLanguages, func(l string) bool { return strings.EqualFold(l, p) }) {
+			return fmt.Errorf("preference %q is not one of match_languages", p)
+		}
+	}
+	if !enabled(m.Config.LenientTags) {
+		var invalid []string
+		for _, l := range m.Config.MatchLanguages {
+			if l == "*" {
+				continue
+			}
+			l = strings.TrimSuffix(l, "-*")
+			// grandfathered tags without a modern equivalent (e.g. i-enochian) have no known language
+			if tag, err := language.Parse(l); err != nil {
+				invalid = append(invalid, l)
+			} else if _, bc := tag.Base(); bc != language.Exact {
+				invalid = append(invalid, l)
+			}
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("invalid language tags in match_languages or match_languages_file: %s (use 'lenient_tags true' to accept them anyway)", strings.Join(invalid, ", "))
+		}
 	}
 	return nil
 }
 
-// Match returns true if the request matches all requirements. If fails and fallback value is set returns true and uses fallback value.
+// Match returns true if the request matches all requirements. If language negotiation fails and one of fallback languages
+// or fallback value is set the fallback is used and the language requirement is satisfied according to MatchOnFallback. Configured charsets, encodings and media types must be negotiated successfully,
+// where a missing Accept-Encoding header accepts any coding: identity if it is offered, otherwise the first of MatchEncodings.
 func (m *Matcher) Match(r *http.Request) bool {
+	if m.bypassed(r) {
+		m.logger.Debug("bypassing negotiation", zap.String("remoteAddr", r.RemoteAddr))
+		return m.Config.BypassMatch == nil || *m.Config.BypassMatch
+	}
+	mapPlaceholders(r)
+	varLanguage := replace(r, m.Config.VarLanguage)
+	if len(varLanguage) > 0 {
+		m.setVar(r, varLanguage+"_vary", strings.Join(m.varyHeaders(r), ","))
+	}
+	if enabled(m.Config.RequireHeader) && len(m.Config.ForceLanguage) == 0 && len(strings.TrimSpace(joinedHeader(r, m.headerName(r)))) == 0 {
+		m.logger.Debug("missing required header", zap.String("header", m.headerName(r)))
+		return false
+	}
+	fallbackValue := replace(r, m.Config.FallbackValue)
 
 	languageMatch, locale := false, ""
-	if len(m.Config.MatchLanguages) == 0 {
+	if len(m.Config.MatchLanguages) == 0 && len(m.Config.ForceLanguage) == 0 {
+		// nothing to negotiate, but downstream handlers can still rely on the variable
 		languageMatch = true
+		if len(fallbackValue) > 0 && len(varLanguage) > 0 {
+			m.logger.Debug("using fallback value", zap.String(varName(m.varPrefix, varLanguage), fallbackValue))
+			caddyhttp.SetVar(r.Context(), varName(m.varPrefix, varLanguage), fallbackValue)
+		}
 	} else {
-		languageMatch, locale = m.matchLanguage(r)
-		if languageMatch && len(m.Config.VarLanguage) > 0 {
-			m.logger.Debug("matched value", zap.String(m.Config.VarLanguage, locale))
-			caddyhttp.SetVar(r.Context(), "langneg_"+m.Config.VarLanguage, locale)
-		} else if len(m.Config.FallbackValue) > 0 && len(m.Config.VarLanguage) > 0 {
-			m.logger.Debug("using fallback value", zap.String(m.Config.VarLanguage, m.Config.FallbackValue))
-			caddyhttp.SetVar(r.Context(), "langneg_"+m.Config.VarLanguage, m.Config.FallbackValue)
-			return true
+		result := m.matchLanguage(r)
+		languageMatch, locale = result.match, result.value
+		if enabled(m.Config.StoreConfidence) && len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_confidence", strings.ToLower(result.confidence.String()))
 		}
-	}
-
-	return languageMatch
-}
-
-func (m *Matcher) matchLanguage(r *http.Request) (bool, string) {
-	match, result := false, ""
-	headerValue := r.Header.Get("Accept-Language")
-	m.logger.Debug("Header Accept-Language", zap.String("headerValue", headerValue))
-	m.logger.Debug("Match language values", zap.Strings("matchLanguages", m.Config.MatchLanguages))
-
-	tag, idx := language.MatchStrings(m.LanguageMatcher, headerValue)
-	fmt.Print(idx)
-	match = !tag.IsRoot()
-	if match {
-		if m.Config.FullLocale {
-			var res []string
-			b, bc := tag.Base()
-			r, rc := tag.Region()
-			s, sc := tag.Script()
-
-			if bc == language.Exact {
-				res = append(res, b.String())
+		if enabled(m.Config.StoreAccepted) && len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_accepted", m.acceptedLanguages(r))
+		}
+		outcome, outcomeValue := resultNoMatch, ""
+		if languageMatch {
+			outcome, outcomeValue = resultMatched, locale
+			if result.reason == reasonRequested || (m.wildcard >= 0 && result.index == m.wildcard) {
+				// any language may be requested (or accepted by the `*` offer), which must not blow up the cardinality of metrics
+				outcome, outcomeValue = resultRequested, ""
 			}
-
-			if rc == language.Exact {
-				res = append(res, r.String())
+		}
+		if languageMatch && len(varLanguage) > 0 {
+			stored := locale
+			if m.Config.TopN > 1 {
+				// the derived variables below describe the negotiated language only
+				stored = m.topLanguages(r, result)
 			}
-
-			if sc == language.Exact {
-				res = append(res, s.String())
+			caddyhttp.SetVar(r.Context(), varName(m.varPrefix, varLanguage), stored)
+			m.setVar(r, varLanguage+"_tag", canonicalTag(result.tag).String())
+			if enabled(m.Config.StoreIndex) {
+				m.setVar(r, varLanguage+"_index", strconv.Itoa(result.index))
 			}
-			result = strings.Join(res, "-")
-		} else {
-			b, _ := tag.Base()
-			result = b.String()
-		}
-	} else {
-		result = ""
+			if enabled(m.Config.StoreQuality) && result.source == "header" {
+				header := parseHeader(r, joinedHeader(r, m.headerName(r)))
+				m.setVar(r, varLanguage+"_q", formatQuality(header.matchedQuality(result.tag)))
+			}
+			if enabled(m.Config.StoreTimezone) || len(m.Config.TimezoneMap) > 0 {
+				if tz := m.timezone(result.tag); len(tz) > 0 {
+					m.setVar(r, varLanguage+"_tz", tz)
+				}
+			}
+			if enabled(m.Config.DisplayNames) {
+				m.setVar(r, varLanguage+"_name", m.displayName(result.value))
+			}
+			if enabled(m.Config.StoreSubtags) {
+				if region, rc := result.tag.Region(); rc == language.Exact {
+					m.setVar(r, varLanguage+"_region", applyCase(region.String(), m.Config.RegionCase))
+				}
+				if script, sc := result.tag.Script(); sc == language.Exact {
+					m.setVar(r, varLanguage+"_script", applyCase(script.String(), m.Config.ScriptCase))
+				}
+			}
+		} else if fallback, ok := m.fallback(r, result, fallbackValue); ok && result.reason != reasonExcluded && (len(varLanguage) > 0 || m.Config.MatchOnFallback != nil) {
+			if len(varLanguage) > 0 {
+				caddyhttp.SetVar(r.Context(), varName(m.varPrefix, varLanguage), fallback)
+			}
+			languageMatch = m.Config.MatchOnFallback == nil || *m.Config.MatchOnFallback
+			outcome, outcomeValue = resultFallback, fallback
+		} else if unmatched := replace(r, m.Config.UnmatchedValue); len(unmatched) > 0 && len(varLanguage) > 0 {
+			caddyhttp.SetVar(r.Context(), varName(m.varPrefix, varLanguage), unmatched)
+		}
+		if outcome == resultFallback {
+			result.value, result.reason, result.source = outcomeValue, reasonFallback, "fallback"
+		}
+		if len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_reason", result.reason)
+		}
+		setResult(r, m.Config.Namespace, result)
+		m.logNegotiation(r, result)
+		if enabled(m.Config.Metrics) {
+			metrics.matches.WithLabelValues(outcomeValue, outcome).Inc()
+		}
+		if enabled(m.Config.Negate) {
+			// fallback is stored above, but matching is decided by negotiation only
+			languageMatch = !result.match
+		}
+	}
+	if !languageMatch {
+		return false
+	}
+
+	if len(m.Config.MatchCharsets) > 0 {
+		charsetMatch, charset := m.matchCharset(r)
+		if !charsetMatch {
+			return false
+		}
+		if len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_charset", charset)
+		}
+	}
+
+	if len(m.Config.MatchEncodings) > 0 {
+		encodingMatch, encoding := m.matchEncoding(r)
+		if !encodingMatch {
+			return false
+		}
+		if len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_encoding", encoding)
+		}
+	}
+
+	if len(m.Config.MatchMediaTypes) > 0 {
+		mediaTypeMatch, mediaType := m.matchMediaType(r)
+		if !mediaTypeMatch {
+			return false
+		}
+		if len(varLanguage) > 0 {
+			m.setVar(r, varLanguage+"_media_type", mediaType)
+		}
+	}
+
+	return true
+}
+
+// setVar stores value in the `<VarPrefix><name>` variable.
+func (m *Matcher) setVar(r *http.Request, name, value string) {
+	name = varName(m.varPrefix, name)
+	caddyhttp.SetVar(r.Context(), name, value)
+}
+
+// enabled reports whether an optional boolean option is set to true.
+func enabled(option *bool) bool {
+	return option != nil && *option
+}
+
+// varName composes the name of a variable holding negotiation results. A nil prefix means the default `langneg_`.
+func varName(prefix *string, name string) string {
+	if prefix == nil {
+		return "langneg_" + name
+	}
+	return *prefix + name
+}
+
+// validVarName returns true if name is usable in placeholders like {vars.langneg_lang}: ASCII letters, digits and
+// underscores, placeholders (e.g. {http.request.host.labels.0}) are replaced per request and not checked.
+func validVarName(name string) bool {
+	placeholder := false
+	for _, c := range name {
+		switch {
+		case placeholder:
+			placeholder = c != '}'
+		case c == '{':
+			placeholder = true
+		case c != '_' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z'):
+			return false
+		}
+	}
+	return !placeholder
+}
+
+// namespaced returns the prefix of variable names in a namespace, e.g. `langneg_ui_` for namespace ui. An empty
+// namespace returns prefix unchanged.
+func namespaced(prefix *string, namespace string) *string {
+	if len(namespace) == 0 {
+		return prefix
+	}
+	p := varName(prefix, namespace+"_")
+	return &p
+}
+
+// replace expands placeholders (e.g. {env.DEFAULT_LANG}) in a config value using the request's replacer.
+func replace(r *http.Request, value string) string {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return value
+	}
+	return repl.ReplaceAll(value, "")
+}
+
+// negotiation holds the outcome of language negotiation.
+type negotiation struct {
+	match bool
+	value string
+	tag   language.Tag
+	// position of the matched language in MatchLanguages, -1 for no match
+	index      int
+	confidence language.Confidence
+	// short explanation of the outcome, one of the reason* constants
+	reason string
+	// where the matched language came from: cookie, query, path, subdomain, header, referer or forced (see ForceLanguage)
+	source string
+}
+
+// Reasons of negotiation outcomes stored in `<var_language>_reason`.
+const (
+	reasonMatched         = "matched"
+	reasonFallback        = "fallback"
+	reasonNoHeader        = "no_header"
+	reasonBelowConfidence = "below_confidence"
+	reasonRejected        = "rejected_q0"
+	reasonNoMatch         = "no_match"
+	reasonRequested       = "requested"
+	reasonInferredBase    = "inferred_base"
+	reasonExcluded        = "excluded"
+	reasonForced          = "forced"
+)
+
+func (m *Matcher) matchLanguage(r *http.Request) negotiation {
+	result := negotiation{tag: language.Und, index: -1, confidence: language.No}
+	if len(m.Config.ForceLanguage) > 0 {
+		result.match, result.value, result.tag, result.confidence = true, m.Config.ForceLanguage, language.Make(m.Config.ForceLanguage), language.Exact
+		result.index = slices.IndexFunc(m.Config.MatchLanguages, func(l string) bool { return strings.EqualFold(l, m.Config.ForceLanguage) })
+		result.reason, result.source = reasonForced, "forced"
+		return result
+	}
+
+	for _, source := range m.sources() {
+		if m.matchSource(r, source, &result) {
+			break
+		}
+	}
+	if !result.tag.IsRoot() && result.source == "header" && enabled(m.Config.RequireExplicitBase) && !m.explicitBase(r, result.tag) {
+		result.tag, result.index, result.reason = language.Und, -1, reasonInferredBase
+	}
+	if !result.tag.IsRoot() && m.isExcluded(r, result) {
+		result.tag, result.index, result.reason = language.Und, -1, reasonExcluded
+	}
+	if !result.tag.IsRoot() && result.confidence < m.minConfidence {
+		result.tag, result.index, result.reason = language.Und, -1, reasonBelowConfidence
+	}
+	result.match = !result.tag.IsRoot()
+	if result.match {
+		if value, ok := m.formatLanguage(result.tag); ok {
+			result.value, result.reason = value, reasonMatched
+		} else {
+			result.match, result.tag, result.index = false, language.Und, -1
+		}
+	}
+	if !result.match && enabled(m.Config.AlwaysMatch) && result.source == "header" && result.reason != reasonNoHeader {
+		if requested := parseHeader(r, joinedHeader(r, m.headerName(r))).desired; len(requested) > 0 {
+			if value, ok := m.formatLanguage(requested[0]); ok {
+				result.match, result.value, result.tag, result.index, result.reason = true, value, requested[0], -1, reasonRequested
+			}
+		}
+	}
+	if !result.match {
+		result.source = ""
+		if len(result.reason) == 0 {
+			result.reason = reasonNoMatch
+		}
+	}
+	return result
+}
+
+// displayName returns the name of the language stored as value (see DisplayNames), e.g. Deutsch for de, or
+// Österreichisches Deutsch for de-AT with FullLocale.
+func (m *Matcher) displayName(value string) string {
+	tag, err := language.Parse(value)
+	if err != nil {
+		return value
+	}
+	if name := m.namer.Name(tag); len(name) > 0 {
+		return name
+	}
+	return value
+}
+
+// topLanguages returns the value of result followed by the values of the offered languages negotiated for the
+// desired languages of the header one by one, in order of preference, TopN at most, joined by commas.
+func (m *Matcher) topLanguages(r *http.Request, result negotiation) string {
+	if result.source != "header" {
+		return result.value
+	}
+	values := []string{result.value}
+	header := parseHeader(r, joinedHeader(r, m.headerName(r)))
+	matcher, _, _, _ := m.acceptableMatcher(header.rejected)
+	for _, desired := range header.desired {
+		if len(values) >= m.Config.TopN {
+			break
+		}
+		tag, _, confidence := matcher.Match(desired)
+		if tag.IsRoot() || confidence < m.minConfidence || slices.ContainsFunc(m.excluded, func(e language.Tag) bool {
+			return excludes(e, tag) || excludes(e, desired)
+		}) {
+			continue
+		}
+		if value, ok := m.formatLanguage(tag); ok && !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// isExcluded returns true if the negotiated language or, if it was negotiated from the header, the requested language
+// it was negotiated for is one of Exclude (see excludes).
+func (m *Matcher) isExcluded(r *http.Request, result negotiation) bool {
+	if len(m.excluded) == 0 {
+		return false
+	}
+	tags := []language.Tag{result.tag}
+	if result.source == "header" {
+		header := parseHeader(r, joinedHeader(r, m.headerName(r)))
+		if i := header.matchedDesired(result.tag); i >= 0 {
+			tags = append(tags, header.desired[i])
+		}
+	}
+	for _, tag := range tags {
+		for _, e := range m.excluded {
+			if excludes(e, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludes returns true if tag has the base language of excluded and the script and region excluded has explicitly,
+// so `en-IN` excludes en-IN and en-Latn-IN, but neither en nor en-GB. An explicit script is compared with the likely
+// script of tag, so zh-Hant excludes zh-TW as well.
+func excludes(excluded, tag language.Tag) bool {
+	eb, es, er := excluded.Raw()
+	if b, _ := tag.Base(); b != eb {
+		return false
+	}
+	if s, _ := tag.Script(); es != (language.Script{}) && s != es {
+		return false
+	}
+	_, _, r := tag.Raw()
+	return er == (language.Region{}) || r == er
+}
+
+// logNegotiation writes a single structured entry summarizing the negotiation for the request, for log based analytics.
+func (m *Matcher) logNegotiation(r *http.Request, result negotiation) {
+	entry := m.logger.Check(m.logLevel, "language negotiated")
+	if entry == nil {
+		return
+	}
+	entry.Write(
+		zap.String("header", joinedHeader(r, m.headerName(r))),
+		zap.String("language", result.value),
+		zap.Stringer("tag", canonicalTag(result.tag)),
+		zap.Int("index", result.index),
+		zap.String("confidence", strings.ToLower(result.confidence.String())),
+		zap.String("source", result.source),
+		zap.String("reason", result.reason),
+		zap.Bool("fallback", result.reason == reasonFallback),
+	)
+}
+
+// parseCIDR parses an IP range in CIDR notation or a single IP address.
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	return ipNet, err
+}
+
+// bypassed returns true if the client IP of the request is in one of BypassCIDRs. The client IP determined by
+// Caddy (taking trusted proxies into account) is preferred over the remote address of the connection.
+func (m *Matcher) bypassed(r *http.Request) bool {
+	if len(m.bypassNets) == 0 {
+		return false
+	}
+	address, _ := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string)
+	if len(address) == 0 {
+		return inNets(remoteIP(r), m.bypassNets)
+	}
+	return inNets(net.ParseIP(address), m.bypassNets)
+}
+
+// trusted returns true if the request comes from one of TrustedCIDRs. Unlike bypassed, it checks the remote address
+// of the connection, since the client IP determined by Caddy is that of the client behind the proxy.
+func (m *Matcher) trusted(r *http.Request) bool {
+	return len(m.trustedNets) > 0 && inNets(remoteIP(r), m.trustedNets)
+}
+
+// remoteIP returns the IP address of the remote end of the connection, nil if it is unknown.
+func remoteIP(r *http.Request) net.IP {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
+// inNets returns true if ip is in one of nets.
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// explicitBase returns true if one of the languages in the header has the base language of tag. Macrolanguages and
+// their most common member (e.g. zh and cmn) are considered the same base language.
+func (m *Matcher) explicitBase(r *http.Request, tag language.Tag) bool {
+	base := macroBase(tag)
+	for _, d := range parseHeader(r, joinedHeader(r, m.headerName(r))).desired {
+		if macroBase(d) == base {
+			return true
+		}
+	}
+	return false
+}
+
+// macroBase returns the base language of tag, replacing the most common member of a macrolanguage by it.
+func macroBase(tag language.Tag) language.Base {
+	if canonical, err := language.Macro.Canonicalize(tag); err == nil {
+		tag = canonical
+	}
+	b, _ := tag.Base()
+	return b
+}
+
+// defaultSources are the sources of language preferences in their default order of precedence (see Sources).
+var defaultSources = []string{"cookie", "query", "path", "subdomain", "header", "referer"}
+
+// sources returns the sources of language preferences in the order they are consulted.
+func (m *Matcher) sources() []string {
+	if len(m.Config.Sources) == 0 {
+		return defaultSources
+	}
+	return m.Config.Sources
+}
+
+// matchSource negotiates the language preferences of the request from source into result. It returns true if the
+// source yields an offered language, sources which are not configured (e.g. cookie without CookieName) yield none.
+func (m *Matcher) matchSource(r *http.Request, source string, result *negotiation) bool {
+	tag, index, confidence, ok := language.Und, -1, language.No, false
+	switch source {
+	case "cookie":
+		if cookieName := replace(r, m.Config.CookieName); len(cookieName) > 0 {
+			if cookie, err := r.Cookie(cookieName); err == nil {
+				tag, index, confidence, ok = m.matchOverride(cookie.Value)
+			}
+		}
+	case "query":
+		if queryParam := replace(r, m.Config.QueryParam); len(queryParam) > 0 {
+			tag, index, confidence, ok = m.matchOverride(r.URL.Query().Get(queryParam))
+		}
+	case "path":
+		if enabled(m.Config.PathPrefix) {
+			segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			tag, index, confidence, ok = m.offeredLanguage(segment)
+		}
+	case "subdomain":
+		if m.Config.SubdomainIndex != nil {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			labels := strings.Split(host, ".")
+			if i := *m.Config.SubdomainIndex; i >= 0 && i < len(labels) {
+				tag, index, confidence, ok = m.offeredLanguage(labels[i])
+			}
+		}
+	case "header":
+		// the outcome of the header is kept even without match, it explains why (see negotiation.reason)
+		result.tag, result.index, result.confidence, result.reason = m.matchHeader(r)
+		result.source = source
+		return !result.tag.IsRoot()
+	case "referer":
+		// the referring site is a weak hint, only used without any preferences of the client
+		if len(strings.TrimSpace(joinedHeader(r, m.headerName(r)))) == 0 {
+			tag, index, confidence, ok = m.matchOverride(m.refererLanguage(r))
+		}
+	}
+	if ok {
+		result.tag, result.index, result.confidence, result.source = tag, index, confidence, source
+	}
+	return ok
+}
+
+// refererLanguage returns the language RefererHostMap maps the host of the Referer header to, "" if there is none.
+func (m *Matcher) refererLanguage(r *http.Request) string {
+	if len(m.refererHostMap) == 0 {
+		return ""
+	}
+	referer := r.Referer()
+	if len(referer) == 0 {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return m.refererHostMap[strings.ToLower(u.Hostname())]
+}
+
+// headerName returns the name of the request header holding language preferences: TrustedHeader if a trusted proxy
+// sent it, otherwise HeaderName.
+func (m *Matcher) headerName(r *http.Request) string {
+	if len(m.Config.TrustedHeader) > 0 && len(r.Header.Values(m.Config.TrustedHeader)) > 0 && m.trusted(r) {
+		return m.Config.TrustedHeader
+	}
+	if len(m.Config.HeaderName) == 0 {
+		return "Accept-Language"
+	}
+	return m.Config.HeaderName
+}
+
+// varyHeaders returns the request headers the outcome of Match depends on, for the `Vary` response header: those of
+// the configured sources of language preferences, followed by the headers of configured charsets, encodings and media types.
+func (m *Matcher) varyHeaders(r *http.Request) []string {
+	var headers []string
+	if len(m.Config.MatchLanguages) > 0 && len(m.Config.ForceLanguage) == 0 {
+		for _, source := range m.sources() {
+			switch source {
+			case "cookie":
+				if len(m.Config.CookieName) > 0 {
+					headers = append(headers, "Cookie")
+				}
+			case "header":
+				if len(m.Config.TrustedHeader) > 0 && m.trusted(r) {
+					headers = append(headers, m.Config.TrustedHeader)
+				}
+				if len(m.Config.HeaderName) == 0 {
+					headers = append(headers, "Accept-Language")
+				} else {
+					headers = append(headers, m.Config.HeaderName)
+				}
+			case "referer":
+				if len(m.refererHostMap) > 0 {
+					headers = append(headers, "Referer")
+				}
+			}
+		}
+	}
+	if len(m.Config.MatchCharsets) > 0 {
+		headers = append(headers, "Accept-Charset")
+	}
+	if len(m.Config.MatchEncodings) > 0 {
+		headers = append(headers, "Accept-Encoding")
+	}
+	if len(m.Config.MatchMediaTypes) > 0 {
+		headers = append(headers, "Accept")
+	}
+	return headers
+}
+
+// acceptedLanguages returns the comma separated canonical tags the client accepts, sorted by descending weight.
+func (m *Matcher) acceptedLanguages(r *http.Request) string {
+	header := parseHeader(r, joinedHeader(r, m.headerName(r)))
+	accepted := make([]string, len(header.desired))
+	for i, tag := range header.desired {
+		accepted[i] = tag.String()
+	}
+	return strings.Join(accepted, ",")
+}
+
+// matchHeader negotiates the language preferences from the request header against offered languages.
+// For no match it reports the reason if it is more specific than reasonNoMatch.
+func (m *Matcher) matchHeader(r *http.Request) (language.Tag, int, language.Confidence, string) {
+	headerValue := joinedHeader(r, m.headerName(r))
+	if len(strings.TrimSpace(headerValue)) == 0 {
+		return language.Und, -1, language.No, reasonNoHeader
+	}
+	if m.cache == nil {
+		return m.negotiateHeader(parseHeader(r, headerValue), headerValue)
+	}
+	o := m.offered()
+	if cached, ok := m.cache.get(headerValue, o); ok {
+		return cached.tag, cached.index, cached.confidence, cached.reason
+	}
+	tag, index, confidence, reason := m.negotiateHeader(parseHeader(r, headerValue), headerValue)
+	m.cache.put(headerValue, o, headerResult{tag: tag, index: index, confidence: confidence, reason: reason})
+	return tag, index, confidence, reason
+}
+
+// negotiateHeader negotiates a non-empty header value parsed into header, see matchHeader. Negotiate shares it.
+func (m *Matcher) negotiateHeader(header *parsedHeader, headerValue string) (language.Tag, int, language.Confidence, string) {
+	if header.malformed != nil {
+		// a single malformed element invalidates the header, treated like a missing one rather than guessing
+		return language.Und, -1, language.No, reasonNoHeader
+	}
+	desired := header.desired
+	if enabled(m.Config.TopPreferenceOnly) && len(desired) > 1 {
+		desired = desired[:1]
+	}
+	matcher, tags, positions, excluded := m.acceptableMatcher(header.rejected)
+	if header.defaultIndex == 0 {
+		// the client prefers the default language over any specific one
+		return m.defaultOffered(tags, positions)
+	}
+	if enabled(m.Config.Macrolanguage) {
+		desired = macrolanguageTags(desired, tags)
+	}
+	tag, idx, confidence := negotiate(matcher, desired)
+	if enabled(m.Config.Comprehends) && confidence <= language.Low {
+		if i, c := comprehensible(desired, tags); c > confidence {
+			tag, idx, confidence = tags[i], i, c
+		}
+	}
+	if !tag.IsRoot() {
+		tag = m.regionalTag(positions[idx], tag, desired...)
+	}
+	if tag.IsRoot() && m.wildcard >= 0 && len(desired) > 0 {
+		// none of the specific languages is acceptable, so accept the client's top preference
+		return desired[0], m.wildcard, language.Exact, ""
+	}
+	if tag.IsRoot() && header.anyLanguage && len(tags) > 1 && (!enabled(m.Config.TopPreferenceOnly) || topIsAny(headerValue)) {
+		// the client accepts any language (`*`), so the first acceptable offered one is as good as any
+		return tags[1], positions[1], language.Exact, ""
+	}
+	if tag.IsRoot() && header.defaultIndex > 0 && !enabled(m.Config.TopPreferenceOnly) {
+		return m.defaultOffered(tags, positions)
+	}
+	if tag.IsRoot() && excluded {
+		return tag, positions[idx], confidence, reasonRejected
+	}
+	return tag, positions[idx], confidence, ""
+}
+
+// defaultOffered returns the default language of the site for `i-default` (RFC 2277): DefaultLanguage if it is one of
+// the offered tags (with their positions), otherwise the first of them.
+func (m *Matcher) defaultOffered(tags []language.Tag, positions []int) (language.Tag, int, language.Confidence, string) {
+	if len(tags) < 2 {
+		return language.Und, -1, language.No, ""
+	}
+	for i := 1; i < len(tags); i++ {
+		if strings.EqualFold(m.Config.MatchLanguages[positions[i]], m.Config.DefaultLanguage) {
+			return tags[i], positions[i], language.Exact, ""
+		}
+	}
+	return tags[1], positions[1], language.Exact, ""
+}
+
+// topIsAny returns true if `*` is the client's most preferred language range.
+func topIsAny(header string) bool {
+	accepted := parseQualityValues(header)
+	return len(accepted) > 0 && accepted[0].value == "*"
+}
+
+// regionalTag returns the first of requested languages with the base language of tag, if tag was offered by a
+// `ll-*` entry at position. Otherwise, it returns tag.
+func (m *Matcher) regionalTag(position int, tag language.Tag, requested ...language.Tag) language.Tag {
+	if !m.baseWildcards[position] {
+		return tag
+	}
+	for _, r := range requested {
+		if sameBase(tag, r) {
+			return r
+		}
+	}
+	return tag
+}
+
+// sameBase returns true if both tags have the same base language.
+func sameBase(a, b language.Tag) bool {
+	ab, _ := a.Base()
+	bb, _ := b.Base()
+	return ab == bb
+}
+
+// comprehensible returns the index of the first offered tag (skipping language.Und at index 0) mutually intelligible
+// with high confidence for the most preferred desired language, according to language.Comprehends.
+func comprehensible(desired, offered []language.Tag) (int, language.Confidence) {
+	for _, d := range desired {
+		for i := 1; i < len(offered); i++ {
+			if c := language.Comprehends(d, offered[i]); c >= language.High {
+				return i, c
+			}
+		}
+	}
+	return 0, language.No
+}
+
+// acceptableMatcher returns a matcher (and its languages with their positions in MatchLanguages) for available languages
+// the client did not reject explicitly, and whether any of them is rejected. The matcher is the one of the available
+// languages unless one of them is rejected.
+func (m *Matcher) acceptableMatcher(rejected []string) (language.Matcher, []language.Tag, []int, bool) {
+	o := m.offered()
+	if len(rejected) == 0 {
+		return o.matcher, o.tags, o.positions, false
+	}
+	tags, positions := []language.Tag{language.Und}, []int{-1}
+	for i := 1; i < len(o.tags); i++ {
+		if !isRejected(rejected, o.tags[i]) {
+			tags = append(tags, o.tags[i])
+			positions = append(positions, o.positions[i])
+		}
+	}
+	if len(tags) == len(o.tags) {
+		return o.matcher, o.tags, o.positions, false
+	}
+	return m.newMatcher(tags), tags, positions, true
+}
+
+// newMatcher creates a matcher for tags according to Mode.
+func (m *Matcher) newMatcher(tags []language.Tag) language.Matcher {
+	if m.Config.Mode == "basic_filtering" {
+		return basicFilter(tags)
+	}
+	return language.NewMatcher(tags)
+}
+
+// formatLanguage turns a negotiated tag into the value stored in the variable. It returns false if the tag lacks
+// a subtag required by LocaleFormat or FullLocaleStrict, a two-letter base language required by ISO6391Only or a
+// close serving locale.
+func (m *Matcher) formatLanguage(tag language.Tag) (string, bool) {
+	value, ok := m.formatTag(tag)
+	if len(m.Config.LocaleSeparator) > 0 && len(m.Config.StoreFormat) == 0 && m.serving == nil {
+		// StoreFormat has separators of its own, serving locales are stored as configured
+		value = strings.ReplaceAll(value, "-", m.Config.LocaleSeparator)
+	}
+	return value, ok
+}
+
+// formatTag formats tag with `-` separators, see formatLanguage.
+func (m *Matcher) formatTag(tag language.Tag) (string, bool) {
+	if b, _ := tag.Base(); enabled(m.Config.ISO6391Only) && len(b.String()) != 2 {
+		// language.Base prefers ISO 639-1 codes, three letters mean there is none
+		return "", false
+	}
+	if m.serving != nil {
+		_, idx, confidence := m.serving.Match(canonicalTag(tag))
+		if confidence < m.minConfidence {
+			return "", false
+		}
+		return m.Config.ServingLocales[idx], true
+	}
+	if len(m.Config.LocaleFormat) > 0 {
+		return formatLocale(canonicalTag(tag), m.Config.LocaleFormat)
+	}
+	if len(m.Config.StoreFormat) > 0 {
+		tag = canonicalTag(tag)
+		if enabled(m.Config.SuppressDefaultRegion) {
+			tag = suppressDefaultRegion(tag)
+		}
+		value, _ := m.expandStoreFormat(tag)
+		return value, true
+	}
+	if enabled(m.Config.FullLocale) && enabled(m.Config.FullLocaleStrict) {
+		if _, rc := canonicalTag(tag).Region(); rc != language.Exact {
+			return "", false
+		}
+	}
+	if enabled(m.Config.FullLocale) && enabled(m.Config.SuppressDefaultRegion) {
+		tag = suppressDefaultRegion(canonicalTag(tag))
+	}
+	if m.Config.Canonicalize == nil || *m.Config.Canonicalize {
+		tag = canonicalTag(tag)
+		if enabled(m.Config.FullLocale) {
+			return tag.String(), true
+		}
+		b, _ := tag.Base()
+		return b.String(), true
+	}
+	if enabled(m.Config.FullLocale) {
+		var res []string
+		b, bc := tag.Base()
+		r, rc := tag.Region()
+		s, sc := tag.Script()
+
+		if bc == language.Exact {
+			res = append(res, b.String())
+		}
+
+		if rc == language.Exact {
+			res = append(res, r.String())
+		}
+
+		if sc == language.Exact {
+			res = append(res, s.String())
+		}
+		return strings.Join(res, "-"), true
+	}
+	b, _ := tag.Base()
+	return b.String(), true
+}
+
+// suppressDefaultRegion drops the region of tag if it is the likely region of its language (and script) according to
+// CLDR, e.g. en-US becomes en and zh-Hant-TW becomes zh-Hant, but pt-PT and zh-TW are kept.
+func suppressDefaultRegion(tag language.Tag) language.Tag {
+	r, rc := tag.Region()
+	if rc != language.Exact {
+		return tag
+	}
+	b, _ := tag.Base()
+	parts := []interface{}{b}
+	if s, sc := tag.Script(); sc == language.Exact {
+		parts = append(parts, s)
+	}
+	withoutRegion, err := language.Compose(parts...)
+	if err != nil {
+		return tag
+	}
+	if likely, _ := withoutRegion.Region(); likely != r {
+		return tag
+	}
+	return withoutRegion
+}
+
+// formatLocale renders the subtags of tag required by format, all of which must be present.
+func formatLocale(tag language.Tag, format string) (string, bool) {
+	b, bc := tag.Base()
+	s, sc := tag.Script()
+	r, rc := tag.Region()
+	switch format {
+	case "lang-region":
+		return b.String() + "-" + r.String(), bc == language.Exact && rc == language.Exact
+	case "lang-Script-region":
+		return b.String() + "-" + s.String() + "-" + r.String(), bc == language.Exact && sc == language.Exact && rc == language.Exact
+	}
+	return b.String(), bc == language.Exact
+}
+
+// expandStoreFormat renders the canonical tag according to StoreFormat, dropping a separator (`-` or `_`) right before
+// a token which is empty for tag (e.g. `{region}` of `de`). It returns false for unknown tokens.
+func (m *Matcher) expandStoreFormat(tag language.Tag) (string, bool) {
+	var sb strings.Builder
+	format := m.Config.StoreFormat
+	for len(format) > 0 {
+		start := strings.IndexByte(format, '{')
+		end := strings.IndexByte(format[max(start, 0):], '}') + max(start, 0)
+		if start < 0 || end < start {
+			sb.WriteString(format)
+			break
+		}
+		literal, token := format[:start], format[start+1:end]
+		format = format[end+1:]
+		value := ""
+		switch token {
+		case "base":
+			if b, bc := tag.Base(); bc == language.Exact {
+				value = b.String()
+			}
+		case "script":
+			if sc, c := tag.Script(); c == language.Exact {
+				value = applyCase(sc.String(), m.Config.ScriptCase)
+			}
+		case "region":
+			if r, rc := tag.Region(); rc == language.Exact {
+				value = applyCase(r.String(), m.Config.RegionCase)
+			}
+		case "tag":
+			value = tag.String()
+		default:
+			return "", false
+		}
+		if len(value) == 0 {
+			literal = strings.TrimRight(literal, "-_")
+		}
+		sb.WriteString(literal)
+		sb.WriteString(value)
+	}
+	return sb.String(), true
+}
+
+// applyCase renders a subtag (in its canonical BCP 47 case) in the configured case. Only lower changes it.
+func applyCase(subtag, letterCase string) string {
+	if letterCase == "lower" {
+		return strings.ToLower(subtag)
+	}
+	return subtag
+}
+
+// signLanguages maps the sign language tags of the IANA registry, which are made of `sgn` and a region, to their
+// preferred values (e.g. sgn-US to ase). Unlike extended language subtags (e.g. sgn-ase or zh-yue), golang.org/x/text
+// doesn't replace them.
+var signLanguages = map[string]string{
+	"sgn-BR": "bzs", "sgn-CO": "csn", "sgn-DE": "gsg", "sgn-DK": "dsl", "sgn-ES": "ssp", "sgn-FR": "fsl",
+	"sgn-GB": "bfi", "sgn-GR": "gss", "sgn-IE": "isg", "sgn-IT": "ise", "sgn-JP": "jsl", "sgn-MX": "mfs",
+	"sgn-NI": "ncs", "sgn-NL": "dse", "sgn-NO": "nsl", "sgn-PT": "psr", "sgn-SE": "swl", "sgn-US": "ase",
+	"sgn-ZA": "sfs",
+}
+
+// macrolanguages maps member languages of the ISO 639-3 macrolanguages to them (e.g. yue to zh). golang.org/x/text
+// only replaces the most common member (e.g. cmn or arb), the language matcher doesn't relate the others.
+var macrolanguages = map[string]string{
+	// Chinese
+	"cdo": "zh", "cjy": "zh", "cmn": "zh", "cnp": "zh", "cpx": "zh", "csp": "zh", "czh": "zh", "czo": "zh",
+	"gan": "zh", "hak": "zh", "hsn": "zh", "lzh": "zh", "mnp": "zh", "nan": "zh", "wuu": "zh", "yue": "zh",
+	// Arabic
+	"aao": "ar", "abh": "ar", "abv": "ar", "acm": "ar", "acq": "ar", "acw": "ar", "acx": "ar", "acy": "ar",
+	"adf": "ar", "aeb": "ar", "aec": "ar", "afb": "ar", "ajp": "ar", "apc": "ar", "apd": "ar", "arb": "ar",
+	"arq": "ar", "ars": "ar", "ary": "ar", "arz": "ar", "auz": "ar", "avl": "ar", "ayh": "ar", "ayl": "ar",
+	"ayn": "ar", "ayp": "ar", "pga": "ar", "shu": "ar", "ssh": "ar",
+	// others
+	"als": "sq", "aln": "sq", "ekk": "et", "khk": "mn", "lvs": "lv", "nb": "no", "nn": "no", "npi": "ne",
+	"pes": "fa", "prs": "fa", "swc": "sw", "swh": "sw", "uzn": "uz", "uzs": "uz", "zsm": "ms",
+}
+
+// macrolanguageTags replaces desired member languages (see macrolanguages) having no offered tag of their own by their
+// macrolanguage, keeping the region and a script differing from the macrolanguage's one (e.g. yue-HK becomes
+// zh-Hant-HK), so that offered scripts and regions of the macrolanguage are matched consistently.
+func macrolanguageTags(desired, offered []language.Tag) []language.Tag {
+	mapped := make([]language.Tag, len(desired))
+	for i, d := range desired {
+		mapped[i] = d
+		b, _ := d.Base()
+		macro, ok := macrolanguages[b.String()]
+		if !ok || slices.ContainsFunc(offered[1:], func(o language.Tag) bool { return sameBase(o, d) }) {
+			continue
+		}
+		parts := []interface{}{language.MustParseBase(macro)}
+		if s, _ := d.Script(); s != likelyScript(macro) {
+			parts = append(parts, s)
+		}
+		if r, rc := d.Region(); rc == language.Exact {
+			parts = append(parts, r)
+		}
+		if tag, err := language.Compose(parts...); err == nil {
+			mapped[i] = tag
+		}
+	}
+	return mapped
+}
+
+// likelyScript returns the script of language according to CLDR likely subtags (e.g. Hans for zh).
+func likelyScript(lang string) language.Script {
+	s, _ := language.Make(lang).Script()
+	return s
+}
+
+// preferredTag replaces sign language tags made of `sgn` and a region by their preferred values.
+func preferredTag(tag language.Tag) language.Tag {
+	if b, _ := tag.Base(); b.String() != "sgn" {
+		return tag
+	}
+	if preferred, ok := signLanguages[tag.String()]; ok {
+		return language.Make(preferred)
+	}
+	return tag
+}
+
+// canonicalTag strips a negotiated tag of extensions added by language.Matcher (e.g. en-u-rg-gbzzzz) and of subtags
+// which were not matched exactly, returning its canonical form (e.g. zh-Hant-TW).
+func canonicalTag(tag language.Tag) language.Tag {
+	var parts []interface{}
+	if b, bc := tag.Base(); bc == language.Exact {
+		parts = append(parts, b)
+	}
+	if s, sc := tag.Script(); sc == language.Exact {
+		parts = append(parts, s)
+	}
+	if r, rc := tag.Region(); rc == language.Exact {
+		parts = append(parts, r)
+	}
+	composed, err := language.Compose(parts...)
+	if err != nil {
+		return tag
+	}
+	canonical, err := language.Default.Canonicalize(composed)
+	if err != nil {
+		return composed
+	}
+	return canonical
+}
+
+// fallback returns the default language if the request has no language preferences, otherwise the fallback mapped to
+// a requested language, the first of fallback languages compatible with offered languages or, failing that, the
+// fallback value.
+func (m *Matcher) fallback(r *http.Request, result negotiation, fallbackValue string) (string, bool) {
+	if defaultLanguage := replace(r, m.Config.DefaultLanguage); result.reason == reasonNoHeader && len(defaultLanguage) > 0 {
+		return defaultLanguage, true
+	}
+	if fallback, ok := m.mappedFallback(r); ok {
+		return fallback, true
+	}
+	if fallback, ok := m.matchFallback(); ok {
+		return fallback, true
+	}
+	return fallbackValue, len(fallbackValue) > 0
+}
+
+// mappedFallback returns the FallbackMap value of the most preferred requested language, trying the full tag (e.g.
+// de-AT) before its base language (e.g. de).
+func (m *Matcher) mappedFallback(r *http.Request) (string, bool) {
+	if len(m.fallbackMap) == 0 {
+		return "", false
+	}
+	for _, tag := range parseHeader(r, joinedHeader(r, m.headerName(r))).desired {
+		keys := []string{strings.ToLower(canonicalTag(tag).String())}
+		if b, bc := tag.Base(); bc == language.Exact {
+			keys = append(keys, b.String())
+		}
+		for _, key := range keys {
+			if served, ok := m.fallbackMap[key]; ok {
+				return served, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchFallback tries FallbackLanguages in order and returns the first one compatible with an offered language.
+func (m *Matcher) matchFallback() (string, bool) {
+	for _, l := range m.Config.FallbackLanguages {
+		tag, _, confidence := m.offered().matcher.Match(preferredTag(language.Make(l)))
+		if confidence != language.No && !tag.IsRoot() {
+			if value, ok := m.formatLanguage(tag); ok {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchOverride matches a language explicitly requested by the user (e.g. via cookie or query parameter) against offered languages.
+// It returns false if value is not a valid language tag or none of the offered languages matches it.
+func (m *Matcher) matchOverride(value string) (language.Tag, int, language.Confidence, bool) {
+	if len(value) == 0 {
+		return language.Und, -1, language.No, false
+	}
+	requested, err := language.Parse(value)
+	if err != nil {
+		return language.Und, -1, language.No, false
+	}
+	requested = preferredTag(requested)
+	o := m.offered()
+	tag, idx, confidence := o.matcher.Match(requested)
+	if confidence == language.No || tag.IsRoot() {
+		if m.wildcard >= 0 && !requested.IsRoot() {
+			return requested, m.wildcard, language.Exact, true
+		}
+		return language.Und, -1, language.No, false
+	}
+	return m.regionalTag(o.positions[idx], tag, requested), o.positions[idx], confidence, true
+}
+
+// offeredLanguage returns the offered language equal to value. Unlike matchOverride it does not negotiate,
+// so it suits values which are part of the site structure (e.g. path segments).
+func (m *Matcher) offeredLanguage(value string) (language.Tag, int, language.Confidence, bool) {
+	if len(value) == 0 {
+		return language.Und, -1, language.No, false
+	}
+	requested, err := language.Parse(value)
+	if err != nil {
+		return language.Und, -1, language.No, false
+	}
+	requested = preferredTag(requested)
+	o := m.offered()
+	for i, tag := range o.tags {
+		if i > 0 && (tag == requested || m.baseWildcards[o.positions[i]] && sameBase(tag, requested)) {
+			return requested, o.positions[i], language.Exact, true
+		}
+	}
+	return language.Und, -1, language.No, false
+}
+
+func (m *Matcher) matchCharset(r *http.Request) (bool, string) {
+	headerValue := joinedHeader(r, "Accept-Charset")
+	m.logger.Debug("Header Accept-Charset", zap.String("headerValue", headerValue))
+	m.logger.Debug("Match charset values", zap.Strings("matchCharsets", m.Config.MatchCharsets))
+
+	if len(headerValue) == 0 {
+		// no Accept-Charset header means that any charset is acceptable
+		return true, m.Config.MatchCharsets[0]
+	}
+	return negotiateToken(parseQualityValues(headerValue), m.Config.MatchCharsets)
+}
+
+func (m *Matcher) matchEncoding(r *http.Request) (bool, string) {
+	headerValue := joinedHeader(r, "Accept-Encoding")
+	m.logger.Debug("Header Accept-Encoding", zap.String("headerValue", headerValue))
+	m.logger.Debug("Match encoding values", zap.Strings("matchEncodings", m.Config.MatchEncodings))
+
+	if len(strings.TrimSpace(headerValue)) == 0 {
+		// no Accept-Encoding header means that any coding is acceptable, identity is preferred if offered
+		for _, e := range m.Config.MatchEncodings {
+			if strings.EqualFold(e, "identity") {
+				return true, e
+			}
+		}
+		return true, m.Config.MatchEncodings[0]
+	}
+	return negotiateEncoding(parseQualityValues(headerValue), m.Config.MatchEncodings)
+}
+
+func (m *Matcher) matchMediaType(r *http.Request) (bool, string) {
+	headerValue := joinedHeader(r, "Accept")
+	m.logger.Debug("Header Accept", zap.String("headerValue", headerValue))
+	m.logger.Debug("Match media type values", zap.Strings("matchMediaTypes", m.Config.MatchMediaTypes))
+
+	if len(headerValue) == 0 {
+		// no Accept header means that any media type is acceptable
+		return true, m.Config.MatchMediaTypes[0]
 	}
-	return match, result
+	return negotiateMediaType(parseQualityValues(headerValue), m.Config.MatchMediaTypes)
 }
 
 // Interface guards
@@ -189,4 +2154,6 @@ var (
 	_ caddyfile.Unmarshaler    = (*Matcher)(nil)
 	_ caddy.Provisioner        = (*Matcher)(nil)
 	_ caddy.Validator          = (*Matcher)(nil)
+	_ caddy.CleanerUpper       = (*Matcher)(nil)
+	_ json.Unmarshaler         = (*Matcher)(nil)
 )
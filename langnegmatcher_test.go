@@ -0,0 +1,605 @@
+package langnegmatcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newMatcher parses a `langneg { ... }` Caddyfile block and provisions the matcher.
+func newMatcher(t testing.TB, input string) *Matcher {
+	t.Helper()
+	m := &Matcher{}
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("parsing %q: %v", input, err)
+	}
+	provision(t, m)
+	return m
+}
+
+// provision provisions and validates m, cleaning it up when the test ends.
+func provision(t testing.TB, m *Matcher) {
+	t.Helper()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	if err := m.Provision(ctx); err != nil {
+		t.Fatalf("provisioning: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Cleanup() })
+	if m.stop == nil {
+		// the default logger of a bare context writes debug entries to stderr, but the goroutine refreshing
+		// files_root reads it already
+		m.logger = zap.NewNop()
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("validating: %v", err)
+	}
+}
+
+// newRequest returns a GET request for target carrying headers, with the vars and replacer Caddy puts in the context.
+func newRequest(target string, headers map[string]string) (*http.Request, map[string]any) {
+	if target == "" {
+		target = "http://example.com/"
+	}
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	vars := map[string]any{}
+	ctx := context.WithValue(r.Context(), caddyhttp.VarsCtxKey, vars)
+	ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, caddy.NewReplacer())
+	return r.WithContext(ctx), vars
+}
+
+// match runs m against a request for target carrying headers and returns the result and the variables set.
+func match(m *Matcher, target string, headers map[string]string) (bool, map[string]any) {
+	r, vars := newRequest(target, headers)
+	return m.Match(r), vars
+}
+
+// matchCase is a request against a matcher configured by the options of a `langneg` block and its expected outcome.
+type matchCase struct {
+	name    string
+	config  string
+	target  string
+	headers map[string]string
+	matched bool
+	// vars are the expected variables, a nil value means that the variable must not be set
+	vars map[string]any
+}
+
+// runMatchCases runs each case against a new matcher.
+func runMatchCases(t *testing.T, cases []matchCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\n"+tc.config+"\n}")
+			matched, vars := match(m, tc.target, tc.headers)
+			if matched != tc.matched {
+				t.Errorf("matched = %v, want %v (vars %v)", matched, tc.matched, vars)
+			}
+			for name, want := range tc.vars {
+				if got, ok := vars[name]; want == nil && ok {
+					t.Errorf("%s = %v, want it unset", name, got)
+				} else if want != nil && got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// acceptLanguage returns the headers of a request with the Accept-Language header set to value.
+func acceptLanguage(value string) map[string]string {
+	return map[string]string{"Accept-Language": value}
+}
+
+func TestMatchEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		config   string
+		header   string
+		matched  bool
+		encoding any
+	}{
+		{"negotiated", "encoding br gzip", "gzip, br;q=0.5", true, "gzip"},
+		{"rejected", "encoding br gzip", "deflate", false, nil},
+		{"missing header prefers identity", "encoding br identity", "", true, "identity"},
+		{"missing header takes first", "encoding br gzip", "", true, "br"},
+		{"missing header ignores fallback", "encoding br gzip\nfallback_value en", "", true, "br"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nmatch_languages en\nvar_language lang\n"+tc.config+"\n}")
+			headers := map[string]string{"Accept-Language": "en"}
+			if tc.header != "" {
+				headers["Accept-Encoding"] = tc.header
+			}
+			matched, vars := match(m, "", headers)
+			if matched != tc.matched {
+				t.Errorf("matched = %v, want %v", matched, tc.matched)
+			}
+			if got := vars["langneg_lang_encoding"]; got != tc.encoding {
+				t.Errorf("langneg_lang_encoding = %v, want %v", got, tc.encoding)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	yes := true
+	want := Config{MatchLanguages: []string{"de", "en"}, FullLocale: &yes, VarLanguage: "lang", FallbackValue: "en"}
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  Config
+	}{
+		{"flat", `{"match_languages": ["de", "en"], "full_locale": true, "var_language": "lang", "fallback_value": "en"}`, want},
+		{"legacy", `{"Config": {"MatchLanguages": ["de", "en"], "FullLocale": true, "VarLanguage": "lang", "FallbackValue": "en"}, "LanguageMatcher": null}`, want},
+		{"flat wins", `{"Config": {"MatchLanguages": ["fr"], "VarLanguage": "lang"}, "match_languages": ["de", "en"], "full_locale": true, "fallback_value": "en"}`, want},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var m Matcher
+			if err := caddy.StrictUnmarshalJSON([]byte(tc.input), &m); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Config, tc.want) {
+				t.Errorf("config = %+v, want %+v", m.Config, tc.want)
+			}
+		})
+	}
+	for _, input := range []string{
+		`{"match_language": ["de"]}`,
+		`{"Config": {"MatchLanguage": ["de"]}}`,
+		`{"Config": {"match_languages": ["de"]}}`,
+	} {
+		var m Matcher
+		if err := caddy.StrictUnmarshalJSON([]byte(input), &m); err == nil {
+			t.Errorf("%s: expected error for unknown field", input)
+		}
+	}
+}
+
+func TestMarshalJSONIsFlat(t *testing.T) {
+	b, err := json.Marshal(&Matcher{Config: Config{MatchLanguages: []string{"de"}, VarLanguage: "lang"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"match_languages":["de"],"var_language":"lang"}`; got != want {
+		t.Errorf("JSON = %s, want %s", got, want)
+	}
+}
+
+func TestRejectedLanguages(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "rejected only offer", config: "match_languages en\nvar_language lang", headers: acceptLanguage("fr, en;q=0"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+		{name: "rejected only offer with fallback", config: "match_languages en\nvar_language lang\nfallback_value en", headers: acceptLanguage("fr, en;q=0"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_reason": "fallback"}},
+		{name: "other offer", config: "match_languages en fr\nvar_language lang", headers: acceptLanguage("fr;q=0.1, en;q=0"),
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+		{name: "range rejects regions", config: "match_languages en-US de\nvar_language lang\nfull_locale true", headers: acceptLanguage("en;q=0, de;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+		{name: "range rejects only region", config: "match_languages en-US de\nvar_language lang", headers: acceptLanguage("en-US;q=0"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+	})
+}
+
+func TestMultipleHeaderLines(t *testing.T) {
+	m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\ncharset utf-8 iso-8859-1\n}")
+	r, vars := newRequest("", nil)
+	r.Header.Add("Accept-Language", "fr, en;q=0.5")
+	r.Header.Add("Accept-Language", "de;q=0.8")
+	r.Header.Add("Accept-Charset", "utf-8;q=0.1")
+	r.Header.Add("Accept-Charset", "iso-8859-1")
+	if !m.Match(r) {
+		t.Fatalf("no match, vars %v", vars)
+	}
+	if got := vars["langneg_lang"]; got != "de" {
+		t.Errorf("langneg_lang = %v, want de from the second line", got)
+	}
+	if got := vars["langneg_lang_charset"]; got != "iso-8859-1" {
+		t.Errorf("langneg_lang_charset = %v, want iso-8859-1 from the second line", got)
+	}
+}
+
+func TestAcceptAnyLanguage(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "first offer", config: "match_languages de en\nvar_language lang\nstore_index true", headers: acceptLanguage("*"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_index": "0"}},
+		{name: "full locale", config: "match_languages pt-BR en\nvar_language lang\nfull_locale true", headers: acceptLanguage("*"),
+			matched: true, vars: map[string]any{"langneg_lang": "pt-BR"}},
+		{name: "explicit preference first", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("en, *;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "unoffered preference", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("fr, *;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+	})
+}
+
+func TestUnmarshalCaddyfileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"unknown option", "langneg {\nmatch_language en\n}", `unrecognized langneg option "match_language"`},
+		{"unknown option after valid one", "langneg {\nmatch_languages en\nvar_lang lang\n}", `unrecognized langneg option "var_lang"`},
+		{"missing argument", "langneg {\nvar_language\n}", "wrong argument count"},
+		{"invalid boolean", "langneg {\nfull_locale maybe\n}", "maybe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Matcher{}
+			err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tc.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %q, want it to contain %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSingleOffer(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "preferred", config: "match_languages en\nvar_language lang\nstore_confidence true", headers: acceptLanguage("en, de;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_confidence": "exact"}},
+		{name: "regional", config: "match_languages en\nvar_language lang\nstore_confidence true", headers: acceptLanguage("en-US, de;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_confidence": "exact"}},
+		{name: "less preferred", config: "match_languages en\nvar_language lang", headers: acceptLanguage("de, en;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "not accepted", config: "match_languages en\nvar_language lang", headers: acceptLanguage("ja"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+	})
+}
+
+// BenchmarkMatch compares negotiation with a single offered language to several offered ones, parsing the header for
+// every request. Parsing and canonicalizing the tags take most of the time, the language matcher is as fast for a
+// single offered language, so it needs no special case.
+func BenchmarkMatch(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		offer  string
+		header string
+	}{
+		{"single offer preferred", "en", "en, de;q=0.8"},
+		{"single offer regional", "en", "en-US, de;q=0.8"},
+		{"several offers preferred", "en de fr es it", "en, de;q=0.8"},
+		{"several offers regional", "en de fr es it", "en-US, de;q=0.8"},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := newMatcher(b, "langneg {\nmatch_languages "+bc.offer+"\nvar_language lang\n}")
+			r, _ := newRequest("", acceptLanguage(bc.header))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// fresh variables for every request, as they hold the parsed header
+				m.Match(r.WithContext(context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{})))
+			}
+		})
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	ui := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\nnamespace ui\n}")
+	content := newMatcher(t, "langneg {\nmatch_languages fr en\nvar_language lang\nnamespace content\nfallback_value en\n}")
+	r, vars := newRequest("", acceptLanguage("de, fr;q=0.5"))
+	if !ui.Match(r) || !content.Match(r) {
+		t.Fatalf("no match, vars %v", vars)
+	}
+	for name, want := range map[string]string{"langneg_ui_lang": "de", "langneg_content_lang": "fr", "langneg_ui_lang_reason": "matched", "langneg_content_lang_reason": "matched"} {
+		if got := vars[name]; got != want {
+			t.Errorf("%s = %v, want %s", name, got, want)
+		}
+	}
+	if got, ok := vars["langneg_lang"]; ok {
+		t.Errorf("langneg_lang = %v, want it unset", got)
+	}
+	cl := &ContentLanguage{varSource: varSource{VarLanguage: "lang", Namespace: "content"}}
+	if got := cl.negotiated(r); got != "fr" {
+		t.Errorf("handler in namespace content read %q, want fr", got)
+	}
+}
+
+func TestExtendedLanguageSubtags(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "extlang requested", config: "match_languages yue zh\nvar_language lang", headers: acceptLanguage("zh-yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "yue"}},
+		{name: "extlang offered", config: "match_languages zh-yue zh\nvar_language lang", headers: acceptLanguage("yue-HK"),
+			matched: true, vars: map[string]any{"langneg_lang": "yue"}},
+		{name: "extlang full locale", config: "match_languages zh-yue zh\nvar_language lang\nfull_locale true", headers: acceptLanguage("yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "yue"}},
+		{name: "min nan", config: "match_languages nan zh\nvar_language lang", headers: acceptLanguage("zh-min-nan"),
+			matched: true, vars: map[string]any{"langneg_lang": "nan"}},
+		{name: "sign language extlang", config: "match_languages ase en\nvar_language lang", headers: acceptLanguage("sgn-ase"),
+			matched: true, vars: map[string]any{"langneg_lang": "ase"}},
+		{name: "sign language region", config: "match_languages sgn-US en\nvar_language lang", headers: acceptLanguage("ase"),
+			matched: true, vars: map[string]any{"langneg_lang": "ase"}},
+	})
+}
+
+func TestGrandfatheredTags(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "i-klingon", config: "match_languages tlh en\nvar_language lang", headers: acceptLanguage("i-klingon"),
+			matched: true, vars: map[string]any{"langneg_lang": "tlh"}},
+		{name: "i-navajo", config: "match_languages nv en\nvar_language lang", headers: acceptLanguage("i-navajo"),
+			matched: true, vars: map[string]any{"langneg_lang": "nv"}},
+		{name: "offered", config: "match_languages i-klingon art-lojban\nvar_language lang", headers: acceptLanguage("jbo"),
+			matched: true, vars: map[string]any{"langneg_lang": "jbo"}},
+		{name: "no-bok", config: "match_languages nb en\nvar_language lang", headers: acceptLanguage("no-bok"),
+			matched: true, vars: map[string]any{"langneg_lang": "nb"}},
+		{name: "unmappable ignored", config: "match_languages en de\nvar_language lang\nstore_confidence true", headers: acceptLanguage("i-enochian, de;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_confidence": "exact"}},
+		{name: "unmappable only", config: "match_languages en de\nvar_language lang", headers: acceptLanguage("i-enochian"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+	})
+}
+
+func TestGrandfatheredTagWithoutEquivalentRejected(t *testing.T) {
+	m := &Matcher{Config: Config{MatchLanguages: []string{"en", "i-enochian"}}}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Cleanup()
+	if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "i-enochian") {
+		t.Errorf("error = %v, want i-enochian to be rejected in match_languages", err)
+	}
+}
+
+func TestMixedCaseHeader(t *testing.T) {
+	var cases []matchCase
+	for _, header := range []string{"en-us", "EN-US", "En-Us", "en-US;Q=0.9", "EN-us, DE;q=0.5"} {
+		cases = append(cases, matchCase{name: header, config: "match_languages en-US de\nvar_language lang\nfull_locale true", headers: acceptLanguage(header),
+			matched: true, vars: map[string]any{"langneg_lang": "en-US", "langneg_lang_tag": "en-US"}})
+	}
+	cases = append(cases, matchCase{name: "mixed case offer", config: "match_languages ZH-hant-tw\nvar_language lang\nfull_locale true", headers: acceptLanguage("zh-TW"),
+		matched: true, vars: map[string]any{"langneg_lang": "zh-Hant-TW"}})
+	runMatchCases(t, cases)
+}
+
+func TestMacrolanguage(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "yue without option", config: "match_languages zh en\nvar_language lang", headers: acceptLanguage("yue"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+		{name: "yue", config: "match_languages zh en\nvar_language lang\nmacrolanguage true", headers: acceptLanguage("yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh"}},
+		{name: "wuu", config: "match_languages zh en\nvar_language lang\nmacrolanguage true", headers: acceptLanguage("wuu, en;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh"}},
+		{name: "cmn", config: "match_languages zh en\nvar_language lang", headers: acceptLanguage("cmn"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh"}},
+		{name: "yue prefers traditional", config: "match_languages zh-Hans zh-Hant\nvar_language lang\nfull_locale true\nmacrolanguage true", headers: acceptLanguage("yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hant"}},
+		{name: "member offered", config: "match_languages zh yue\nvar_language lang\nmacrolanguage true", headers: acceptLanguage("yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "yue"}},
+		{name: "egyptian arabic", config: "match_languages ar en\nvar_language lang\nmacrolanguage true", headers: acceptLanguage("arz"),
+			matched: true, vars: map[string]any{"langneg_lang": "ar"}},
+		{name: "moroccan arabic region", config: "match_languages ar-EG ar-MA\nvar_language lang\nfull_locale true\nmacrolanguage true", headers: acceptLanguage("ary-MA"),
+			matched: true, vars: map[string]any{"langneg_lang": "ar-MA"}},
+		{name: "hans and hant offered", config: "match_languages zh-Hans zh-Hant\nvar_language lang\nfull_locale true", headers: acceptLanguage("zh-Hant-HK"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hant"}},
+	})
+}
+
+func TestQualityTies(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "first listed wins", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("en;q=0.8, de;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "first listed wins reversed", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("de;q=0.8, en;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+		{name: "implicit weights", config: "match_languages de en fr\nvar_language lang", headers: acceptLanguage("fr, en, de"),
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+		{name: "higher weight later", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("en;q=0.8, de;q=0.9"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+		{name: "tie after unoffered", config: "match_languages de en\nvar_language lang\nstore_accepted true", headers: acceptLanguage("en;q=0.5, de;q=0.5, fr"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_accepted": "fr,en,de"}},
+	})
+}
+
+func TestStoreQuality(t *testing.T) {
+	config := "match_languages en de\nvar_language lang\nstore_quality true"
+	runMatchCases(t, []matchCase{
+		{name: "weighted", config: config, headers: acceptLanguage("en-GB;q=0.8, de;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_q": "0.8"}},
+		{name: "default weight", config: config, headers: acceptLanguage("de, en;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_q": "1.0"}},
+		{name: "explicit one", config: config, headers: acceptLanguage("de;q=1, en;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_q": "1.0"}},
+		{name: "three decimals", config: config, headers: acceptLanguage("fr, de;q=0.125"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_q": "0.125"}},
+		{name: "wildcard", config: config, headers: acceptLanguage("fr, *;q=0.3"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_q": "0.3"}},
+		{name: "not from header", config: config + "\nquery_param hl", target: "http://example.com/?hl=de", headers: acceptLanguage("en"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_q": nil}},
+	})
+}
+
+func TestScripts(t *testing.T) {
+	config := "match_languages zh-Hans zh-Hant\nvar_language lang\nfull_locale true\nstore_subtags true"
+	runMatchCases(t, []matchCase{
+		{name: "zh-TW", config: config, headers: acceptLanguage("zh-TW"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hant", "langneg_lang_script": "Hant"}},
+		{name: "zh-HK", config: config, headers: acceptLanguage("zh-HK"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hant"}},
+		{name: "zh-CN", config: config, headers: acceptLanguage("zh-CN"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hans", "langneg_lang_script": "Hans"}},
+		{name: "zh-SG", config: config, headers: acceptLanguage("zh-SG"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hans"}},
+		{name: "zh", config: config, headers: acceptLanguage("zh"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hans"}},
+		{name: "explicit script", config: config, headers: acceptLanguage("zh-Hant-CN"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-Hant"}},
+		{name: "base only", config: "match_languages zh-Hans zh-Hant\nvar_language lang", headers: acceptLanguage("zh-TW"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh", "langneg_lang_tag": "zh-Hant"}},
+		{name: "regional offers", config: "match_languages zh-CN zh-TW\nvar_language lang\nfull_locale true", headers: acceptLanguage("zh-HK"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh-TW"}},
+	})
+}
+
+func TestIDefault(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "first offered", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("i-default"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_tag": "de"}},
+		{name: "default_language", config: "match_languages de en\nvar_language lang\ndefault_language en", headers: acceptLanguage("i-default"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "preference", config: "match_languages de en\nvar_language lang\npreference en", headers: acceptLanguage("i-default"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "most preferred wins", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("i-default, en;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+		{name: "lower ranked", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("en, i-default;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "lower ranked applies", config: "match_languages de en\nvar_language lang", headers: acceptLanguage("fr, i-default;q=0.5"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+		{name: "not english", config: "match_languages en-GB fr\nvar_language lang\ndefault_language fr", headers: acceptLanguage("i-default"),
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+	})
+}
+
+func TestLogNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		header string
+		fields map[string]any
+	}{
+		{"matched", "", "de", map[string]any{"language": "de", "source": "header", "reason": "matched", "fallback": false}},
+		{"fallback", "fallback_languages en", "fr", map[string]any{"language": "en", "source": "fallback", "reason": "fallback", "fallback": true}},
+		{"cached", "cache_size 2", "en-GB", map[string]any{"language": "en", "confidence": "high"}},
+		{"malformed", "fallback_value en", "en;x", map[string]any{"header": "en;x", "reason": "fallback"}},
+		{"rejected", "", "de;q=0, en;q=0.5", map[string]any{"language": "en", "reason": "matched"}},
+		{"cookie", "cookie_name lang", "en", map[string]any{"language": "de", "source": "cookie"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\n"+tc.config+"\n}")
+			core, logs := observer.New(zapcore.DebugLevel)
+			m.logger = zap.New(core)
+			for i := 0; i < 2; i++ {
+				r, _ := newRequest("", acceptLanguage(tc.header))
+				r.AddCookie(&http.Cookie{Name: "lang", Value: "de"})
+				m.Match(r)
+			}
+			entries := logs.AllUntimed()
+			if len(entries) != 2 {
+				t.Fatalf("%d log entries for 2 requests, want one each: %v", len(entries), entries)
+			}
+			for _, entry := range entries {
+				if entry.Message != "language negotiated" {
+					t.Errorf("message = %q", entry.Message)
+				}
+				fields := entry.ContextMap()
+				for name, want := range tc.fields {
+					if got := fields[name]; got != want {
+						t.Errorf("%s = %v, want %v", name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRequireExplicitBase(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "explicit region", config: "match_languages zh-Hans en\nvar_language lang\nrequire_explicit_base true", headers: acceptLanguage("zh-TW"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh"}},
+		{name: "explicit base", config: "match_languages en-GB\nvar_language lang\nrequire_explicit_base true", headers: acceptLanguage("en"),
+			matched: true, vars: map[string]any{"langneg_lang": "en"}},
+		{name: "macrolanguage member", config: "match_languages zh\nvar_language lang\nrequire_explicit_base true", headers: acceptLanguage("cmn"),
+			matched: true, vars: map[string]any{"langneg_lang": "zh"}},
+		{name: "comprehended inferred", config: "match_languages da\nvar_language lang\ncomprehends true\nrequire_explicit_base true", headers: acceptLanguage("nb"),
+			matched: false, vars: map[string]any{"langneg_lang": nil, "langneg_lang_reason": "inferred_base"}},
+		{name: "comprehended without option", config: "match_languages da\nvar_language lang\ncomprehends true", headers: acceptLanguage("nb"),
+			matched: true, vars: map[string]any{"langneg_lang": "da"}},
+		{name: "macrolanguage inferred", config: "match_languages zh\nvar_language lang\nmacrolanguage true\nrequire_explicit_base true\nfallback_value en", headers: acceptLanguage("yue"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_reason": "fallback"}},
+		{name: "any language inferred", config: "match_languages de\nvar_language lang\nrequire_explicit_base true", headers: acceptLanguage("fr, *;q=0.5"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+		{name: "other sources unaffected", config: "match_languages de en\nvar_language lang\nrequire_explicit_base true\nquery_param hl", target: "http://example.com/?hl=de", headers: acceptLanguage("fr"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+	})
+}
+
+func TestMalformedHeaders(t *testing.T) {
+	var cases []matchCase
+	for _, header := range []string{";;;q=", "en;q=abc", "de, ;q=0.5", "en-US-", "=", "en;;q=0.5,de"} {
+		cases = append(cases,
+			matchCase{name: header, config: "match_languages en de\nvar_language lang\nstore_confidence true", headers: acceptLanguage(header),
+				matched: false, vars: map[string]any{"langneg_lang": nil, "langneg_lang_reason": "no_header"}},
+			matchCase{name: header + " with fallback", config: "match_languages en de\nvar_language lang\nfallback_value de", headers: acceptLanguage(header),
+				matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_reason": "fallback"}},
+		)
+	}
+	runMatchCases(t, cases)
+}
+
+func TestContentLanguage(t *testing.T) {
+	config := "match_languages en fr de\nvar_language lang\nheader_name Content-Language"
+	runMatchCases(t, []matchCase{
+		{name: "declared language", config: config, headers: map[string]string{"Content-Language": "fr"},
+			matched: true, vars: map[string]any{"langneg_lang": "fr", "langneg_lang_vary": "Content-Language"}},
+		{name: "regional variant", config: config, headers: map[string]string{"Content-Language": "fr-CA"},
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+		{name: "several languages", config: config, headers: map[string]string{"Content-Language": "fr, de"},
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+		{name: "accept-language ignored", config: config, headers: map[string]string{"Content-Language": "fr", "Accept-Language": "de"},
+			matched: true, vars: map[string]any{"langneg_lang": "fr"}},
+		{name: "only accept-language", config: config, headers: map[string]string{"Accept-Language": "de"},
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+		{name: "fallback", config: config + "\nfallback_value en", headers: map[string]string{"Content-Language": "ja"},
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_reason": "fallback"}},
+	})
+}
+
+func TestUndNeverStored(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "only und", config: "match_languages en de\nvar_language lang", headers: acceptLanguage("und"),
+			matched: false, vars: map[string]any{"langneg_lang": nil}},
+		{name: "only und with fallback", config: "match_languages en de\nvar_language lang\nfallback_value de", headers: acceptLanguage("und"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_reason": "fallback"}},
+		{name: "unknown language with fallback", config: "match_languages en de\nvar_language lang\nfallback_value de", headers: acceptLanguage("x-klingon"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_reason": "fallback"}},
+		{name: "offered und", config: "match_languages und en\nlenient_tags true\nvar_language lang\nfallback_value en\nstore_index true", headers: acceptLanguage("und"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_reason": "fallback", "langneg_lang_index": nil}},
+		{name: "index after offered und", config: "match_languages und en\nlenient_tags true\nvar_language lang\nstore_index true", headers: acceptLanguage("en"),
+			matched: true, vars: map[string]any{"langneg_lang": "en", "langneg_lang_index": "1"}},
+		{name: "always match", config: "match_languages en de\nvar_language lang\nalways_match true\nfallback_value de", headers: acceptLanguage("und"),
+			matched: true, vars: map[string]any{"langneg_lang": "de", "langneg_lang_reason": "fallback"}},
+		{name: "top languages", config: "match_languages en de\nvar_language lang\ntop_n 2", headers: acceptLanguage("de, und"),
+			matched: true, vars: map[string]any{"langneg_lang": "de"}},
+	})
+	for name, config := range map[string]Config{
+		"force_language":  {MatchLanguages: []string{"en"}, ForceLanguage: "und"},
+		"serving_locales": {MatchLanguages: []string{"en"}, ServingLocales: []string{"und", "en"}},
+	} {
+		m := &Matcher{Config: config}
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		err := m.Provision(ctx)
+		if err == nil {
+			err = m.Validate()
+			m.Cleanup()
+		}
+		cancel()
+		if err == nil {
+			t.Errorf("%s: expected und to be rejected", name)
+		}
+	}
+}
+
+func TestTopNDerivedVars(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "display names", config: "match_languages en de fr\nvar_language lang\ntop_n 2\ndisplay_names true\ndisplay_locale en", headers: acceptLanguage("de, en;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "de,en", "langneg_lang_name": "German", "langneg_lang_tag": "de"}},
+		{name: "subtags and timezone", config: "match_languages en-US de-AT\nvar_language lang\nfull_locale true\ntop_n 2\nstore_subtags true\nstore_timezone true", headers: acceptLanguage("de-AT, en-US;q=0.8"),
+			matched: true, vars: map[string]any{"langneg_lang": "de-AT,en-US", "langneg_lang_region": "AT", "langneg_lang_tz": "Europe/Vienna"}},
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// caddyfileOptions maps JSON names of Config fields to their Caddyfile options where they differ.
+var caddyfileOptions = map[string]string{
+	"match_charsets":    "charset",
+	"match_encodings":   "encoding",
+	"match_media_types": "media_type",
+}
+
+// MarshalCaddyfile returns the config as a Caddyfile `langneg` block listing all options set to non-default values,
+// in the order of Config fields. Unmarshalling it with UnmarshalFromCaddy results in the same config.
+func (c *Config) MarshalCaddyfile() (string, error) {
+	var sb strings.Builder
+	sb.WriteString("langneg {\n")
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+		if option, ok := caddyfileOptions[name]; ok {
+			name = option
+		}
+		if field.IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			field = field.Elem()
+		}
+		switch v := field.Interface().(type) {
+		case caddy.Duration:
+			fmt.Fprintf(&sb, "\t%s %s\n", name, time.Duration(v))
+		case string:
+			fmt.Fprintf(&sb, "\t%s %s\n", name, quoteToken(v))
+		case bool:
+			fmt.Fprintf(&sb, "\t%s %t\n", name, v)
+		case int:
+			fmt.Fprintf(&sb, "\t%s %d\n", name, v)
+		case []string:
+			quoted := make([]string, len(v))
+			for i, s := range v {
+				quoted[i] = quoteToken(s)
+			}
+			fmt.Fprintf(&sb, "\t%s %s\n", name, strings.Join(quoted, " "))
+		case map[string]string:
+			fmt.Fprintf(&sb, "\t%s {\n", name)
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&sb, "\t\t%s %s\n", quoteToken(k), quoteToken(v[k]))
+			}
+			sb.WriteString("\t}\n")
+		default:
+			return "", fmt.Errorf("marshalling %s: unsupported type %T", name, v)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String(), nil
+}
+
+// quoteToken quotes a Caddyfile token if it would not be read back as a single token otherwise. Within quotes, only
+// quotation marks are escaped by the Caddyfile lexer.
+func quoteToken(token string) string {
+	if len(token) > 0 && token != "{" && token != "}" && !strings.HasPrefix(token, "#") && !strings.ContainsAny(token, " \t\r\n\"'`") {
+		return token
+	}
+	return `"` + strings.ReplaceAll(token, `"`, `\"`) + `"`
+}
@@ -0,0 +1,124 @@
+package langnegmatcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestMarshalCaddyfileRoundTrip(t *testing.T) {
+	index, prefix, yes, no := 1, "", true, false
+	config := Config{
+		MatchLanguages:        []string{"en;q=0.5", "de-AT", "*"},
+		FullLocale:            &yes,
+		VarLanguage:           "lang",
+		FallbackValue:         "en",
+		MatchCharsets:         []string{"utf-8", "iso-8859-1"},
+		MatchEncodings:        []string{"br", "identity"},
+		MatchMediaTypes:       []string{"text/html", "application/json"},
+		CookieName:            "lang",
+		QueryParam:            "hl",
+		PathPrefix:            &yes,
+		SubdomainIndex:        &index,
+		StoreConfidence:       &yes,
+		StoreIndex:            &yes,
+		LenientTags:           &yes,
+		FallbackLanguages:     []string{"pt", "es"},
+		VarPrefix:             &prefix,
+		Namespace:             "ui",
+		StoreSubtags:          &yes,
+		MinConfidence:         "high",
+		HeaderName:            "X-Language",
+		Negate:                &yes,
+		Mode:                  "basic_filtering",
+		Metrics:               &yes,
+		MatchOnFallback:       &no,
+		Preference:            []string{"de-AT"},
+		StoreAccepted:         &yes,
+		DefaultLanguage:       "en",
+		Comprehends:           &yes,
+		Canonicalize:          &no,
+		RequireHeader:         &yes,
+		FallbackMap:           map[string]string{"pt-BR": "pt", "de-CH": "de"},
+		FilesRoot:             "/srv/locales dir",
+		FilesRefresh:          caddy.Duration(5 * time.Minute),
+		RegionCase:            "lower",
+		ScriptCase:            "upper",
+		LocaleFormat:          "{lang}_{REGION}",
+		SuppressDefaultRegion: &yes,
+		TopPreferenceOnly:     &yes,
+		Macrolanguage:         &yes,
+		MatchLanguagesFile:    "languages.txt",
+		RefererHostMap:        map[string]string{"example.de": "de"},
+		FullLocaleStrict:      &yes,
+		StoreQuality:          &yes,
+		AlwaysMatch:           &yes,
+		StoreFormat:           `{language}-"{region}"`,
+		BypassCIDRs:           []string{"10.0.0.0/8", "::1"},
+		BypassMatch:           &yes,
+		CacheSize:             100,
+		LogLevel:              "info",
+		Sources:               []string{"query", "header"},
+		RequireExplicitBase:   &yes,
+		Exclude:               []string{"en-IN"},
+		ForceLanguage:         "de",
+		DisplayNames:          &yes,
+		DisplayLocale:         "en",
+		ISO6391Only:           &yes,
+		UnmatchedValue:        "none",
+		TopN:                  2,
+		TrustedHeader:         "X-Original-Language",
+		TrustedCIDRs:          []string{"192.0.2.0/24"},
+		ServingLocales:        []string{"en_US", "de_AT"},
+		LocaleSeparator:       "_",
+		StoreTimezone:         &yes,
+		TimezoneMap:           map[string]string{"US": "America/Chicago", "pt": "Europe/Lisbon"},
+	}
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsZero() {
+			t.Fatalf("%s is not populated, the round trip would not cover it", value.Type().Field(i).Name)
+		}
+	}
+
+	caddyfileText, err := config.MarshalCaddyfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Config
+	if err := parsed.UnmarshalFromCaddy(caddyfile.NewTestDispenser(caddyfileText)); err != nil {
+		t.Fatalf("parsing\n%s: %v", caddyfileText, err)
+	}
+	if !reflect.DeepEqual(parsed, config) {
+		t.Errorf("round trip of\n%s = %+v, want %+v", caddyfileText, parsed, config)
+	}
+}
+
+func TestMarshalCaddyfileDefaults(t *testing.T) {
+	got, err := (&Config{}).MarshalCaddyfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "langneg {\n}\n"; got != want {
+		t.Errorf("MarshalCaddyfile() = %q, want %q", got, want)
+	}
+}
+
+func TestQuoteToken(t *testing.T) {
+	for token, want := range map[string]string{
+		"en":       "en",
+		"":         `""`,
+		"a b":      `"a b"`,
+		`say "hi"`: `"say \"hi\""`,
+		"{":        `"{"`,
+		"#comment": `"#comment"`,
+		"{vars.x}": "{vars.x}",
+	} {
+		if got := quoteToken(token); got != want {
+			t.Errorf("quoteToken(%q) = %s, want %s", token, got, want)
+		}
+	}
+}
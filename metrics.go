@@ -0,0 +1,54 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// Outcomes of language negotiation reported in the `result` label of metrics.
+const (
+	resultMatched   = "matched"
+	resultFallback  = "fallback"
+	resultNoMatch   = "nomatch"
+	resultRequested = "requested"
+)
+
+// metrics are shared by all matchers with Metrics enabled. Like Caddy's own metrics (as of v2.8) they are registered
+// in the default Prometheus registry, which survives config reloads, so they are registered only once.
+var metrics = struct {
+	init    sync.Once
+	err     error
+	matches *prometheus.CounterVec
+}{}
+
+// initMetrics registers metrics of language negotiation, reusing collectors already registered by other means.
+func initMetrics() error {
+	metrics.init.Do(func() {
+		matches := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "langneg_matches_total",
+			Help: "Counter of language negotiation outcomes by negotiated language.",
+		}, []string{"language", "result"})
+		err := prometheus.Register(matches)
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			matches, err = already.ExistingCollector.(*prometheus.CounterVec), nil
+		}
+		metrics.matches, metrics.err = matches, err
+	})
+	return metrics.err
+}
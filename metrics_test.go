@@ -0,0 +1,37 @@
+package langnegmatcher
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		config   string
+		header   string
+		language string
+		result   string
+	}{
+		{"matched", "match_languages de en", "de", "de", resultMatched},
+		{"fallback", "match_languages de en\nfallback_value en", "fr", "en", resultFallback},
+		{"no match", "match_languages de en", "fr", "", resultNoMatch},
+		{"always_match", "match_languages de en\nalways_match true", "ja", "", resultRequested},
+		{"wildcard offer", "match_languages de *", "ja", "", resultRequested},
+		{"wildcard offer not used", "match_languages de *", "de", "de", resultMatched},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nvar_language lang\nmetrics true\n"+tc.config+"\n}")
+			counter := metrics.matches.WithLabelValues(tc.language, tc.result)
+			before := testutil.ToFloat64(counter)
+			match(m, "", acceptLanguage(tc.header))
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("langneg_matches_total{language=%q,result=%q} increased by %v, want 1", tc.language, tc.result, got)
+			}
+			if got := testutil.ToFloat64(metrics.matches.WithLabelValues(tc.header, resultMatched)); tc.language == "" && got != 0 {
+				t.Errorf("requested language %q counted as label", tc.header)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+	"strings"
+)
+
+// Negotiator chooses the best of a fixed set of offered languages for Accept-Language header values, e.g. for use in
+// other Caddy modules. Build it once with NewNegotiator (e.g. when provisioning the module) and reuse it for every
+// request, it is safe for concurrent use.
+type Negotiator struct {
+	m *Matcher
+}
+
+// NewNegotiator returns a Negotiator for the offered languages. Negotiated languages are the base language, e.g. en,
+// or, with fullLocale set, the canonical tag, e.g. en-US.
+func NewNegotiator(offered []language.Tag, fullLocale bool) *Negotiator {
+	m := &Matcher{Config: Config{FullLocale: &fullLocale}, logger: zap.NewNop(), minConfidence: language.Low, wildcard: -1}
+	m.tags, m.positions = []language.Tag{language.Und}, []int{-1}
+	for _, tag := range offered {
+		// und would be taken for the sentinel of no match, like in Provision
+		if tag = preferredTag(tag); !tag.IsRoot() {
+			m.Config.MatchLanguages = append(m.Config.MatchLanguages, tag.String())
+			m.tags, m.positions = append(m.tags, tag), append(m.positions, len(m.positions)-1)
+		}
+	}
+	m.LanguageMatcher = m.newMatcher(m.tags)
+	m.available.Store(&offer{matcher: m.LanguageMatcher, tags: m.tags, positions: m.positions})
+	return &Negotiator{m: m}
+}
+
+// Negotiate returns whether a language was negotiated for an Accept-Language header value, the negotiated language
+// and the confidence of the match.
+//
+// The header is negotiated exactly like by a langneg matcher with match_languages set to the offered languages and
+// default options: rejected languages (`q=0`) are never chosen, `*` and `i-default` accept the first offered language
+// and a malformed header is treated as a missing one, which matches nothing:
+//
+//	n := langnegmatcher.NewNegotiator([]language.Tag{language.English, language.German}, false)
+//	matched, value, confidence := n.Negotiate(r.Header.Get("Accept-Language"))
+func (n *Negotiator) Negotiate(acceptLanguage string) (bool, string, language.Confidence) {
+	if len(strings.TrimSpace(acceptLanguage)) == 0 {
+		return false, "", language.No
+	}
+	tag, _, confidence, _ := n.m.negotiateHeader(parseHeaderValue(acceptLanguage), acceptLanguage)
+	if tag.IsRoot() || confidence < n.m.minConfidence {
+		return false, "", confidence
+	}
+	value, _ := n.m.formatLanguage(tag)
+	return true, value, confidence
+}
+
+// Negotiate is a shorthand for NewNegotiator(offered, fullLocale).Negotiate(acceptLanguage) for a single header. It
+// builds the language matcher on every call, so callers negotiating per request should reuse a Negotiator instead.
+func Negotiate(offered []language.Tag, acceptLanguage string, fullLocale bool) (bool, string, language.Confidence) {
+	return NewNegotiator(offered, fullLocale).Negotiate(acceptLanguage)
+}
+
+// negotiate returns the best match of matcher for desired languages. Like language.MatchStrings it returns the
+// default of matcher for no match.
+func negotiate(matcher language.Matcher, desired []language.Tag) (language.Tag, int, language.Confidence) {
+	tag, idx, confidence := matcher.Match(desired...)
+	if confidence == language.No {
+		tag, idx, _ = matcher.Match()
+	}
+	return tag, idx, confidence
+}
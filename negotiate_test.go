@@ -0,0 +1,94 @@
+package langnegmatcher
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestNegotiate(t *testing.T) {
+	offered := []language.Tag{language.English, language.German, language.MustParse("pt-BR")}
+	for _, tc := range []struct {
+		header     string
+		fullLocale bool
+		matched    bool
+		value      string
+		confidence language.Confidence
+	}{
+		{"de, en;q=0.5", false, true, "de", language.Exact},
+		{"en-GB", false, true, "en", language.High},
+		{"en-GB", true, true, "en", language.High},
+		{"pt", true, true, "pt-BR", language.Exact},
+		{"ja", false, false, "", language.No},
+		{"*", false, true, "en", language.Exact},
+		{"ja, *;q=0.1", false, true, "en", language.Exact},
+		{"i-default", false, true, "en", language.Exact},
+		{"de;q=0, en;q=0", false, false, "", language.No},
+		{"en;q=0, *", false, true, "de", language.Exact},
+		{";;;q=", false, false, "", language.No},
+		{"", false, false, "", language.No},
+	} {
+		matched, value, confidence := Negotiate(offered, tc.header, tc.fullLocale)
+		if matched != tc.matched || value != tc.value || confidence != tc.confidence {
+			t.Errorf("Negotiate(%q, %v) = %v, %q, %v, want %v, %q, %v", tc.header, tc.fullLocale, matched, value, confidence, tc.matched, tc.value, tc.confidence)
+		}
+	}
+}
+
+// TestNegotiateLikeMatch checks that Negotiate and the matcher agree, as they share the negotiation.
+func TestNegotiateLikeMatch(t *testing.T) {
+	offered := []language.Tag{language.German, language.English, language.MustParse("zh-Hant")}
+	m := newMatcher(t, "langneg {\nmatch_languages de en zh-Hant\nvar_language lang\nfull_locale true\nstore_confidence true\n}")
+	for _, header := range []string{"de", "en-US, de;q=0.5", "zh-TW", "fr", "*", "fr, *;q=0.5", "i-default", "de;q=0", "de;q=0, *", "en;x", "Q=0.5", "EN-us"} {
+		matched, value, confidence := Negotiate(offered, header, true)
+		ok, vars := match(m, "", acceptLanguage(header))
+		if lang, _ := vars["langneg_lang"].(string); matched != ok || value != lang {
+			t.Errorf("%q: Negotiate = %v, %q, Match = %v, %q", header, matched, value, ok, lang)
+		}
+		if matched && vars["langneg_lang_confidence"] != strings.ToLower(confidence.String()) {
+			t.Errorf("%q: Negotiate confidence %v, Match %v", header, confidence, vars["langneg_lang_confidence"])
+		}
+	}
+}
+
+func TestNegotiatorReuse(t *testing.T) {
+	offered := []language.Tag{language.English, language.German, language.MustParse("pt-BR")}
+	n := NewNegotiator(offered, true)
+	headers := []string{"de, en;q=0.5", "pt", "ja", "en;q=0, *", ";;;q=", ""}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, header := range headers {
+				matched, value, confidence := n.Negotiate(header)
+				wantMatched, wantValue, wantConfidence := Negotiate(offered, header, true)
+				if matched != wantMatched || value != wantValue || confidence != wantConfidence {
+					t.Errorf("%q: reused negotiator = %v, %q, %v, want %v, %q, %v", header, matched, value, confidence, wantMatched, wantValue, wantConfidence)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkNegotiate compares reusing a Negotiator to building one for every header.
+func BenchmarkNegotiate(b *testing.B) {
+	offered := []language.Tag{language.English, language.German, language.French, language.Spanish, language.Italian}
+	const header = "en-US, de;q=0.8"
+	b.Run("reused", func(b *testing.B) {
+		n := NewNegotiator(offered, false)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			n.Negotiate(header)
+		}
+	})
+	b.Run("per call", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Negotiate(offered, header, false)
+		}
+	})
+}
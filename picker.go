@@ -0,0 +1,189 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"bytes"
+	"errors"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Picker responds with a language chooser listing the offered languages when language negotiation is ambiguous,
+// i.e. no language was negotiated or only with low confidence. Other requests are passed to the next handler.
+type Picker struct {
+	varSource
+
+	// Languages listed in the chooser. Default: Empty list
+	Languages []string `json:"languages,omitempty"`
+	// Link of each language. `{lang}` is replaced with the language, other placeholders are expanded as well. Default: `/{lang}{http.request.uri}`
+	Link string `json:"link,omitempty"`
+	// File with the html/template of the chooser. Default: "" (a plain list of links)
+	TemplateFile string `json:"template_file,omitempty"`
+	// Status code of the response. Default: 200
+	StatusCode int `json:"status_code,omitempty"`
+
+	template *template.Template
+}
+
+// pickerTemplate is the chooser used without TemplateFile.
+const pickerTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Choose your language</title></head>
+<body>
+<ul>
+{{- range .Languages}}
+<li><a href="{{.Link}}" hreflang="{{.Code}}" lang="{{.Code}}">{{.Code}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`
+
+// pickerData is the context of the chooser template.
+type pickerData struct {
+	// offered languages with their links
+	Languages []pickerLanguage
+	// negotiated language (empty for no match) and the confidence of negotiation (empty without store_confidence)
+	Language   string
+	Confidence string
+}
+
+// pickerLanguage is a language listed in the chooser.
+type pickerLanguage struct {
+	Code string
+	Link string
+}
+
+func init() {
+	caddy.RegisterModule(&Picker{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_picker", parsePicker)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_picker", httpcaddyfile.Before, "redir")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Picker) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_picker",
+		New: func() caddy.Module { return new(Picker) },
+	}
+}
+
+func parsePicker(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := &Picker{}
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (p *Picker) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "languages":
+				p.Languages = append(p.Languages, d.RemainingArgs()...)
+			case "link":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Link = d.Val()
+			case "template_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.TemplateFile = d.Val()
+			case "status_code":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				p.StatusCode = intVal
+			default:
+				if ok, err := p.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_picker option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Provision sets up the module.
+func (p *Picker) Provision(_ caddy.Context) error {
+	if len(p.Link) == 0 {
+		p.Link = "/{lang}{http.request.uri}"
+	}
+	if p.StatusCode == 0 {
+		p.StatusCode = http.StatusOK
+	}
+	var err error
+	if len(p.TemplateFile) > 0 {
+		p.template, err = template.ParseFiles(p.TemplateFile)
+	} else {
+		p.template, err = template.New("picker").Parse(pickerTemplate)
+	}
+	return err
+}
+
+// Validate validates that the module has a usable config.
+func (p *Picker) Validate() error {
+	if len(p.Languages) == 0 {
+		return errors.New("languages are required")
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (p *Picker) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	lang, confidence := p.negotiated(r), p.variable(r, "_confidence")
+	if len(lang) > 0 && confidence != "low" && confidence != "no" {
+		return next.ServeHTTP(w, r)
+	}
+	data := pickerData{Language: lang, Confidence: confidence}
+	for _, l := range p.Languages {
+		data.Languages = append(data.Languages, pickerLanguage{Code: l, Link: replace(r, strings.ReplaceAll(p.Link, "{lang}", l))})
+	}
+	var body bytes.Buffer
+	if err := p.template.Execute(&body, data); err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if vary := p.variable(r, "_vary"); len(vary) > 0 {
+		// the page depends on the sources the matcher negotiated from, like with langneg_vary
+		mergeVary(w.Header(), strings.Split(vary, ","))
+	}
+	w.WriteHeader(p.StatusCode)
+	_, err := body.WriteTo(w)
+	return err
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Picker)(nil)
+	_ caddyfile.Unmarshaler       = (*Picker)(nil)
+	_ caddy.Provisioner           = (*Picker)(nil)
+	_ caddy.Validator             = (*Picker)(nil)
+)
@@ -0,0 +1,83 @@
+package langnegmatcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// TestProtocols negotiates requests of all HTTP versions, which differ in the representation of headers on the wire.
+func TestProtocols(t *testing.T) {
+	m := newMatcher(t, "langneg {\nmatch_languages en de fr\nvar_language lang\nheader_name x-accept-language\ncookie_name lang\nsubdomain_index 0\nsources cookie header subdomain\n}")
+	for _, proto := range []struct {
+		name         string
+		major, minor int
+	}{{"HTTP/1.1", 1, 1}, {"HTTP/2.0", 2, 0}, {"HTTP/3.0", 3, 0}} {
+		t.Run(proto.name, func(t *testing.T) {
+			for _, tc := range []struct {
+				name    string
+				prepare func(r *http.Request)
+				want    string
+			}{
+				{"canonical header", func(r *http.Request) { r.Header.Set("x-accept-language", "de") }, "de"},
+				{"split header", func(r *http.Request) {
+					r.Header.Add("X-Accept-Language", "it")
+					r.Header.Add("X-Accept-Language", "fr;q=0.5")
+				}, "fr"},
+				// HTTP/2 and HTTP/3 clients may send every cookie in a field of its own
+				{"split cookies", func(r *http.Request) {
+					r.Header.Add("Cookie", "a=b")
+					r.Header.Add("Cookie", "lang=fr")
+				}, "fr"},
+				{"authority", func(r *http.Request) { r.Host = "de.example.com:443" }, "de"},
+			} {
+				r, vars := newRequest("", nil)
+				r.Proto, r.ProtoMajor, r.ProtoMinor = proto.name, proto.major, proto.minor
+				tc.prepare(r)
+				if !m.Match(r) || vars["langneg_lang"] != tc.want {
+					t.Errorf("%s: langneg_lang = %v, want %s", tc.name, vars["langneg_lang"], tc.want)
+				}
+			}
+		})
+	}
+}
+
+// TestHTTP2 negotiates a request received by a real HTTP/2 server.
+func TestHTTP2(t *testing.T) {
+	m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\n}")
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{})
+		ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, caddy.NewReplacer())
+		r = r.WithContext(ctx)
+		m.Match(r)
+		lang, _ := caddyhttp.GetVar(r.Context(), "langneg_lang").(string)
+		_, _ = io.WriteString(w, r.Proto+" "+lang)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Accept-Language", "fr")
+	req.Header.Add("Accept-Language", "de;q=0.5")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), "HTTP/2.0 de"; got != want {
+		t.Errorf("response = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Redirect redirects requests to a localized path (e.g. / to /en/) based on the result of language negotiation.
+// Requests whose path already starts with a known language are passed to the next handler to avoid redirect loops.
+type Redirect struct {
+	varSource
+
+	// Redirect target. `{lang}` is replaced with the negotiated language, other placeholders are expanded as well. Default: `/{lang}{http.request.uri}`
+	To string `json:"to,omitempty"`
+	// Languages recognized as the first segment of already localized paths. The negotiated language is always recognized. Default: Empty list
+	Languages []string `json:"languages,omitempty"`
+	// Status code of the redirect (301, 302, 303, 307 or 308). Default: 302
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(&Redirect{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_redirect", parseRedirect)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_redirect", httpcaddyfile.Before, "redir")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Redirect) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_redirect",
+		New: func() caddy.Module { return new(Redirect) },
+	}
+}
+
+func parseRedirect(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	rd := &Redirect{}
+	err := rd.UnmarshalCaddyfile(h.Dispenser)
+	return rd, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (rd *Redirect) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "to":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				rd.To = d.Val()
+			case "languages":
+				rd.Languages = append(rd.Languages, d.RemainingArgs()...)
+			case "status_code":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				intVal, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return err
+				}
+				rd.StatusCode = intVal
+			default:
+				if ok, err := rd.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_redirect option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Provision sets up the module.
+func (rd *Redirect) Provision(_ caddy.Context) error {
+	if len(rd.To) == 0 {
+		rd.To = "/{lang}{http.request.uri}"
+	}
+	if rd.StatusCode == 0 {
+		rd.StatusCode = http.StatusFound
+	}
+	return nil
+}
+
+// Validate validates that the module has a usable config.
+func (rd *Redirect) Validate() error {
+	switch rd.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("status code %d is not a redirect status code (301, 302, 303, 307 or 308)", rd.StatusCode)
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (rd *Redirect) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	lang := rd.negotiated(r)
+	if len(lang) == 0 {
+		return next.ServeHTTP(w, r)
+	}
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	for _, l := range append([]string{lang}, rd.Languages...) {
+		if strings.EqualFold(segment, l) {
+			return next.ServeHTTP(w, r)
+		}
+	}
+	w.Header().Set("Location", replace(r, strings.ReplaceAll(rd.To, "{lang}", lang)))
+	w.WriteHeader(rd.StatusCode)
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Redirect)(nil)
+	_ caddyfile.Unmarshaler       = (*Redirect)(nil)
+	_ caddy.Provisioner           = (*Redirect)(nil)
+	_ caddy.Validator             = (*Redirect)(nil)
+)
@@ -0,0 +1,146 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"golang.org/x/text/language"
+	"net/http"
+	"strings"
+)
+
+// Result is the outcome of language negotiation for Go handlers, which can read it with GetResult instead of parsing
+// the `langneg_` variables.
+type Result struct {
+	// Stored language (see FullLocale), or the fallback if negotiation failed ("" if there is none either)
+	Value string
+	// Canonical tag of the negotiated language (language.Und if negotiation failed)
+	Tag language.Tag
+	// Base language, region and script of Tag, empty if Tag doesn't specify them
+	Base   string
+	Region string
+	Script string
+	// Confidence of the negotiated language
+	Confidence language.Confidence
+	// Origin of Value: cookie, query, path, subdomain, header, referer, fallback, forced or "" (no match)
+	Source string
+	// Short explanation of the outcome, the same as stored in `<var_language>_reason`
+	Reason string
+}
+
+// resultVar is the name of the variable holding the Result of the last langneg matcher evaluated for a request.
+// Matchers can't replace the request context, so the variables already attached to it are used.
+const resultVar = "langneg.result"
+
+// resultVarName returns the name of the variable holding the Result of the last langneg matcher in namespace (see
+// Namespace), resultVar for none.
+func resultVarName(namespace string) string {
+	if len(namespace) == 0 {
+		return resultVar
+	}
+	return "langneg." + namespace + ".result"
+}
+
+// GetResult returns the Result of the last langneg matcher negotiating languages (having match_languages) for the
+// request with context ctx, whatever its namespace.
+func GetResult(ctx context.Context) (*Result, bool) {
+	return GetNamespacedResult(ctx, "")
+}
+
+// GetNamespacedResult returns the Result of the last langneg matcher negotiating languages in namespace for the
+// request with context ctx, so that matchers in different namespaces don't overwrite each other's outcome. For an
+// empty namespace it is the same as GetResult.
+func GetNamespacedResult(ctx context.Context, namespace string) (*Result, bool) {
+	result, ok := caddyhttp.GetVar(ctx, resultVarName(namespace)).(*Result)
+	return result, ok
+}
+
+// setResult stores the outcome of negotiation for GetResult and, with a namespace, GetNamespacedResult.
+func setResult(r *http.Request, namespace string, n negotiation) {
+	tag := canonicalTag(n.tag)
+	result := &Result{Value: n.value, Tag: tag, Confidence: n.confidence, Source: n.source, Reason: n.reason}
+	if !tag.IsRoot() {
+		if b, bc := tag.Base(); bc == language.Exact {
+			result.Base = b.String()
+		}
+		if s, sc := tag.Script(); sc == language.Exact {
+			result.Script = s.String()
+		}
+		if rg, rc := tag.Region(); rc == language.Exact {
+			result.Region = rg.String()
+		}
+	}
+	caddyhttp.SetVar(r.Context(), resultVar, result)
+	if len(namespace) > 0 {
+		caddyhttp.SetVar(r.Context(), resultVarName(namespace), result)
+	}
+}
+
+// placeholdersVar is the name of the variable marking requests whose replacer already provides the `{langneg.*}`
+// placeholders, so that several langneg matchers add them once.
+const placeholdersVar = "langneg.placeholders"
+
+// mapPlaceholders adds the `{langneg.*}` placeholders, resolving to the fields of the Result of the request (see
+// GetResult), and `{langneg.<namespace>.*}` resolving to those of a namespace (see GetNamespacedResult), to the replacer
+// of the request. They resolve to "" until a language is negotiated.
+func mapPlaceholders(r *http.Request) {
+	ctx := r.Context()
+	repl, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok || caddyhttp.GetVar(ctx, placeholdersVar) != nil {
+		return
+	}
+	caddyhttp.SetVar(ctx, placeholdersVar, true)
+	repl.Map(func(key string) (any, bool) {
+		field, ok := strings.CutPrefix(key, "langneg.")
+		if !ok {
+			return nil, false
+		}
+		namespace := ""
+		if i := strings.LastIndexByte(field, '.'); i >= 0 {
+			namespace, field = field[:i], field[i+1:]
+		}
+		result, ok := GetNamespacedResult(ctx, namespace)
+		if !ok {
+			result = &Result{Tag: language.Und}
+		}
+		switch field {
+		case "language":
+			return result.Value, true
+		case "tag":
+			if result.Tag.IsRoot() {
+				return "", true
+			}
+			return result.Tag.String(), true
+		case "base":
+			return result.Base, true
+		case "region":
+			return result.Region, true
+		case "script":
+			return result.Script, true
+		case "confidence":
+			if len(result.Source) == 0 {
+				return "", true
+			}
+			return strings.ToLower(result.Confidence.String()), true
+		case "source":
+			return result.Source, true
+		case "reason":
+			return result.Reason, true
+		}
+		return nil, false
+	})
+}
@@ -0,0 +1,37 @@
+package langnegmatcher
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestNamespacedResults(t *testing.T) {
+	ui := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\nnamespace ui\n}")
+	content := newMatcher(t, "langneg {\nmatch_languages fr en\nvar_language lang\nnamespace content\n}")
+	r, _ := newRequest("", acceptLanguage("de, fr;q=0.5"))
+	ui.Match(r)
+	content.Match(r)
+	for namespace, want := range map[string]string{"ui": "de", "content": "fr", "": "fr"} {
+		result, ok := GetNamespacedResult(r.Context(), namespace)
+		if !ok || result.Value != want {
+			t.Errorf("result of namespace %q = %+v, want %s", namespace, result, want)
+		}
+	}
+	// without namespace, the last matcher wins
+	if result, ok := GetResult(r.Context()); !ok || result.Value != "fr" {
+		t.Errorf("GetResult = %+v, want fr", result)
+	}
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	for placeholder, want := range map[string]string{
+		"{langneg.ui.language}":      "de",
+		"{langneg.ui.source}":        "header",
+		"{langneg.content.language}": "fr",
+		"{langneg.language}":         "fr",
+		"{langneg.other.language}":   "",
+	} {
+		if got := repl.ReplaceKnown(placeholder, ""); got != want {
+			t.Errorf("%s = %q, want %q", placeholder, got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Rewrite internally rewrites the request path to a localized one (e.g. /article to /en/article) based on the result
+// of language negotiation. Paths already starting with the prefix are left unchanged, the query string is preserved.
+type Rewrite struct {
+	varSource
+
+	// Path prefix template. `{lang}` is replaced with the negotiated language, other placeholders are expanded as well. Default: `/{lang}`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func init() {
+	caddy.RegisterModule(&Rewrite{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_rewrite", parseRewrite)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_rewrite", httpcaddyfile.Before, "rewrite")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Rewrite) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_rewrite",
+		New: func() caddy.Module { return new(Rewrite) },
+	}
+}
+
+func parseRewrite(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	rw := &Rewrite{}
+	err := rw.UnmarshalCaddyfile(h.Dispenser)
+	return rw, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (rw *Rewrite) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			switch d.Val() {
+			case "prefix":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				rw.Prefix = d.Val()
+			default:
+				if ok, err := rw.varSource.unmarshalOption(d); err != nil {
+					return err
+				} else if !ok {
+					return d.Errf("unrecognized langneg_rewrite option %q", d.Val())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Provision sets up the module.
+func (rw *Rewrite) Provision(_ caddy.Context) error {
+	if len(rw.Prefix) == 0 {
+		rw.Prefix = "/{lang}"
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (rw *Rewrite) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	lang := rw.negotiated(r)
+	if len(lang) == 0 {
+		return next.ServeHTTP(w, r)
+	}
+	prefix := "/" + strings.Trim(replace(r, strings.ReplaceAll(rw.Prefix, "{lang}", lang)), "/")
+	if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+		return next.ServeHTTP(w, r)
+	}
+	if len(r.URL.RawPath) > 0 {
+		r.URL.RawPath = (&url.URL{Path: prefix}).EscapedPath() + r.URL.RawPath
+	}
+	r.URL.Path = prefix + r.URL.Path
+	r.RequestURI = r.URL.RequestURI()
+	return next.ServeHTTP(w, r)
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Rewrite)(nil)
+	_ caddyfile.Unmarshaler       = (*Rewrite)(nil)
+	_ caddy.Provisioner           = (*Rewrite)(nil)
+)
@@ -0,0 +1,135 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"golang.org/x/text/language"
+	"strings"
+)
+
+// regionTimezones maps regions to the IANA time zone of their capital or most populous area, a default for regions
+// spanning several time zones (e.g. America/New_York for US).
+var regionTimezones = map[string]string{
+	"AE": "Asia/Dubai",
+	"AR": "America/Argentina/Buenos_Aires",
+	"AT": "Europe/Vienna",
+	"AU": "Australia/Sydney",
+	"BE": "Europe/Brussels",
+	"BG": "Europe/Sofia",
+	"BR": "America/Sao_Paulo",
+	"CA": "America/Toronto",
+	"CH": "Europe/Zurich",
+	"CL": "America/Santiago",
+	"CN": "Asia/Shanghai",
+	"CO": "America/Bogota",
+	"CZ": "Europe/Prague",
+	"DE": "Europe/Berlin",
+	"DK": "Europe/Copenhagen",
+	"EE": "Europe/Tallinn",
+	"EG": "Africa/Cairo",
+	"ES": "Europe/Madrid",
+	"FI": "Europe/Helsinki",
+	"FR": "Europe/Paris",
+	"GB": "Europe/London",
+	"GR": "Europe/Athens",
+	"HK": "Asia/Hong_Kong",
+	"HR": "Europe/Zagreb",
+	"HU": "Europe/Budapest",
+	"ID": "Asia/Jakarta",
+	"IE": "Europe/Dublin",
+	"IL": "Asia/Jerusalem",
+	"IN": "Asia/Kolkata",
+	"IR": "Asia/Tehran",
+	"IS": "Atlantic/Reykjavik",
+	"IT": "Europe/Rome",
+	"JP": "Asia/Tokyo",
+	"KE": "Africa/Nairobi",
+	"KR": "Asia/Seoul",
+	"LT": "Europe/Vilnius",
+	"LU": "Europe/Luxembourg",
+	"LV": "Europe/Riga",
+	"MX": "America/Mexico_City",
+	"MY": "Asia/Kuala_Lumpur",
+	"NG": "Africa/Lagos",
+	"NL": "Europe/Amsterdam",
+	"NO": "Europe/Oslo",
+	"NZ": "Pacific/Auckland",
+	"PE": "America/Lima",
+	"PH": "Asia/Manila",
+	"PK": "Asia/Karachi",
+	"PL": "Europe/Warsaw",
+	"PT": "Europe/Lisbon",
+	"RO": "Europe/Bucharest",
+	"RS": "Europe/Belgrade",
+	"RU": "Europe/Moscow",
+	"SA": "Asia/Riyadh",
+	"SE": "Europe/Stockholm",
+	"SG": "Asia/Singapore",
+	"SI": "Europe/Ljubljana",
+	"SK": "Europe/Bratislava",
+	"TH": "Asia/Bangkok",
+	"TR": "Europe/Istanbul",
+	"TW": "Asia/Taipei",
+	"UA": "Europe/Kyiv",
+	"US": "America/New_York",
+	"VN": "Asia/Ho_Chi_Minh",
+	"ZA": "Africa/Johannesburg",
+}
+
+// timezone returns the default time zone of a negotiated tag: the entry of TimezoneMap for the tag (e.g. de-AT), for
+// its explicit region (e.g. AT) or the zone of that region in regionTimezones, for its base language, and finally the
+// entry or zone of its likely region (e.g. DE for de). It returns "" if none is known.
+func (m *Matcher) timezone(tag language.Tag) string {
+	tag = canonicalTag(tag)
+	if tz, ok := m.timezoneTags[strings.ToLower(tag.String())]; ok {
+		return tz
+	}
+	region, confidence := tag.Region()
+	if confidence == language.Exact {
+		if tz := m.regionTimezone(region); len(tz) > 0 {
+			return tz
+		}
+	}
+	base, _ := tag.Base()
+	if tz, ok := m.timezoneBases[base.String()]; ok {
+		return tz
+	}
+	return m.regionTimezone(region)
+}
+
+// regionTimezone returns the entry of TimezoneMap for a region, otherwise its zone in regionTimezones.
+func (m *Matcher) regionTimezone(region language.Region) string {
+	if tz, ok := m.timezoneRegions[region.String()]; ok {
+		return tz
+	}
+	return regionTimezones[region.String()]
+}
+
+// splitTimezoneMap sorts the keys of TimezoneMap into tags, regions and base languages, as a region and a language
+// may share their code (e.g. ES and es). Keys with a subtag separator are tags, matched case insensitively, keys
+// without lowercase letters are regions (e.g. US or 419), the others base languages.
+func (m *Matcher) splitTimezoneMap() {
+	m.timezoneTags, m.timezoneRegions, m.timezoneBases = map[string]string{}, map[string]string{}, map[string]string{}
+	for key, tz := range m.Config.TimezoneMap {
+		switch {
+		case strings.ContainsAny(key, "-_"):
+			m.timezoneTags[strings.ToLower(strings.ReplaceAll(key, "_", "-"))] = tz
+		case key == strings.ToUpper(key):
+			m.timezoneRegions[key] = tz
+		default:
+			m.timezoneBases[strings.ToLower(key)] = tz
+		}
+	}
+}
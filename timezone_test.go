@@ -0,0 +1,48 @@
+package langnegmatcher
+
+import (
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestTimezone(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		timezoneMap map[string]string
+		tag         string
+		want        string
+	}{
+		{"region from table", nil, "es-MX", "America/Mexico_City"},
+		{"likely region", nil, "de", "Europe/Berlin"},
+		{"unknown region", nil, "en-AQ", ""},
+		{"region not taken for language", map[string]string{"ES": "Europe/Madrid"}, "es-MX", "America/Mexico_City"},
+		{"region entry", map[string]string{"ES": "Atlantic/Canary"}, "es-ES", "Atlantic/Canary"},
+		{"language not taken for region", map[string]string{"pt": "America/Sao_Paulo"}, "pt-PT", "Europe/Lisbon"},
+		{"language for its region", map[string]string{"pt": "America/Sao_Paulo"}, "pt-BR", "America/Sao_Paulo"},
+		{"language before likely region", map[string]string{"pt": "Europe/Lisbon"}, "pt", "Europe/Lisbon"},
+		{"region before language", map[string]string{"BR": "America/Manaus", "pt": "Europe/Lisbon"}, "pt-BR", "America/Manaus"},
+		{"tag before region", map[string]string{"pt-br": "America/Recife", "BR": "America/Manaus"}, "pt-BR", "America/Recife"},
+		{"tag with underscore", map[string]string{"de_CH": "Europe/Zurich"}, "de-CH", "Europe/Zurich"},
+		{"numeric region", map[string]string{"419": "America/Mexico_City"}, "es-419", "America/Mexico_City"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Matcher{Config: Config{TimezoneMap: tc.timezoneMap}}
+			m.splitTimezoneMap()
+			if got := m.timezone(language.MustParse(tc.tag)); got != tc.want {
+				t.Errorf("timezone(%s) = %q, want %q", tc.tag, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStoreTimezone(t *testing.T) {
+	runMatchCases(t, []matchCase{
+		{name: "es-MX", config: "match_languages es-ES es-MX\nvar_language lang\nfull_locale true\ntimezone_map {\nES Europe/Madrid\n}", headers: acceptLanguage("es-MX"),
+			matched: true, vars: map[string]any{"langneg_lang": "es-MX", "langneg_lang_tz": "America/Mexico_City"}},
+		{name: "pt-PT", config: "match_languages pt-PT pt-BR\nvar_language lang\nfull_locale true\ntimezone_map {\npt America/Sao_Paulo\n}", headers: acceptLanguage("pt-PT"),
+			matched: true, vars: map[string]any{"langneg_lang": "pt-PT", "langneg_lang_tz": "Europe/Lisbon"}},
+		{name: "pt-BR", config: "match_languages pt-PT pt-BR\nvar_language lang\nfull_locale true\ntimezone_map {\npt America/Sao_Paulo\n}", headers: acceptLanguage("pt-BR"),
+			matched: true, vars: map[string]any{"langneg_lang": "pt-BR", "langneg_lang_tz": "America/Sao_Paulo"}},
+	})
+}
@@ -0,0 +1,58 @@
+package langnegmatcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	maphandler "github.com/caddyserver/caddy/v2/modules/caddyhttp/map"
+)
+
+// TestUpstreamSelection follows the README example of picking a reverse_proxy upstream with map: the matcher of
+// reverse_proxy is evaluated after map, which resolves {upstream} only when the proxy dials it.
+func TestUpstreamSelection(t *testing.T) {
+	m := newMatcher(t, "langneg {\nmatch_languages en de fr\nvar_language lang\nfallback_value en\n}")
+	mapper := &maphandler.Handler{
+		// the Caddyfile adapter expands {vars.langneg_lang}
+		Source:       "{http.vars.langneg_lang}",
+		Destinations: []string{"{upstream}"},
+		Mappings: []maphandler.Mapping{
+			{Input: "de", Outputs: []any{"german-backend:8080"}},
+			{Input: "fr", Outputs: []any{"french-backend:8080"}},
+		},
+		Defaults: []string{"english-backend:8080"},
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := mapper.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"de-AT, en;q=0.5": "german-backend:8080",
+		"fr":              "french-backend:8080",
+		"ja":              "english-backend:8080",
+		"":                "english-backend:8080",
+	} {
+		r, _ := newRequest("", acceptLanguage(header))
+		// the replacer of a Caddy HTTP server, which provides {vars.*}
+		repl := caddyhttp.NewTestReplacer(r)
+		var dialed string
+		err := mapper.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			// reverse_proxy @lang {upstream}
+			if !m.Match(r) {
+				t.Errorf("%q: not matched", header)
+			}
+			dialed = repl.ReplaceAll("{upstream}", "")
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dialed != want {
+			t.Errorf("%q: upstream = %q, want %q", header, dialed, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+)
+
+// varSource names the variables set by a langneg matcher, it is embedded by the handlers reading them.
+type varSource struct {
+	// Variable name (will be prefixed with VarPrefix) holding result of language negotiation. Default: ""
+	VarLanguage string `json:"var_language,omitempty"`
+	// Prefix of variable names, the same as configured in the langneg matcher. Default: nil (`langneg_`)
+	VarPrefix *string `json:"var_prefix,omitempty"`
+	// Namespace of variable names, the same as configured in the langneg matcher. Default: ""
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// language returns the negotiated language of the request, "" if none is set.
+func (s *varSource) language(r *http.Request) string {
+	return s.variable(r, "")
+}
+
+// negotiated returns the language negotiated for the request, "" if none was. The variable alone doesn't tell, as it
+// holds UnmatchedValue if nothing matched, so the reason stored by the matcher is checked as well.
+func (s *varSource) negotiated(r *http.Request) string {
+	switch s.variable(r, "_reason") {
+	case reasonMatched, reasonFallback, reasonRequested, reasonForced:
+	case "":
+		// set by something else than a langneg matcher
+	default:
+		return ""
+	}
+	return s.language(r)
+}
+
+// variable returns the value of the variable with suffix (e.g. `_confidence`) set for the request, "" if none is set.
+func (s *varSource) variable(r *http.Request, suffix string) string {
+	value, _ := caddyhttp.GetVar(r.Context(), varName(namespaced(s.VarPrefix, s.Namespace), replace(r, s.VarLanguage)+suffix)).(string)
+	return value
+}
+
+// unmarshalOption parses the current Caddyfile option if it is one of `var_language`, `var_prefix` and `namespace`,
+// it returns false for other options.
+func (s *varSource) unmarshalOption(d *caddyfile.Dispenser) (bool, error) {
+	switch d.Val() {
+	case "var_language":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		s.VarLanguage = d.Val()
+	case "var_prefix":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		prefix := d.Val()
+		s.VarPrefix = &prefix
+	case "namespace":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		s.Namespace = d.Val()
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package langnegmatcher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestVarSourceOptions(t *testing.T) {
+	for directive, handler := range map[string]caddyfile.Unmarshaler{
+		"langneg_content_language": &ContentLanguage{},
+		"langneg_cookie":           &Cookie{},
+		"langneg_enforce":          &Enforce{},
+		"langneg_picker":           &Picker{},
+		"langneg_redirect":         &Redirect{},
+		"langneg_rewrite":          &Rewrite{},
+		"langneg_vary":             &Vary{},
+	} {
+		t.Run(directive, func(t *testing.T) {
+			if err := handler.UnmarshalCaddyfile(caddyfile.NewTestDispenser(directive + " {\nvar_language lang\nvar_prefix p_\nnamespace ui\n}")); err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the embedded options are part of the handler config, not nested
+			if want := `"var_language":"lang","var_prefix":"p_","namespace":"ui"`; !strings.Contains(string(b), want) {
+				t.Errorf("JSON = %s, want it to contain %s", b, want)
+			}
+			for _, input := range []string{directive + " {\nvar_language\n}", directive + " {\nvar_languages lang\n}"} {
+				if err := handler.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+					t.Errorf("%q: expected error", input)
+				}
+			}
+		})
+	}
+}
+
+func TestVarSourceLookup(t *testing.T) {
+	prefix := "p_"
+	r, vars := newRequest("", nil)
+	vars["p_ui_lang"], vars["p_ui_lang_confidence"], vars["langneg_lang"] = "de", "high", "en"
+	for _, tc := range []struct {
+		source     varSource
+		lang       string
+		confidence string
+	}{
+		{varSource{VarLanguage: "lang"}, "en", ""},
+		{varSource{VarLanguage: "lang", VarPrefix: &prefix, Namespace: "ui"}, "de", "high"},
+		{varSource{VarLanguage: "lang", Namespace: "content"}, "", ""},
+	} {
+		if got := tc.source.language(r); got != tc.lang {
+			t.Errorf("%+v: language = %q, want %q", tc.source, got, tc.lang)
+		}
+		if got := tc.source.variable(r, "_confidence"); got != tc.confidence {
+			t.Errorf("%+v: confidence = %q, want %q", tc.source, got, tc.confidence)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Mateusz Butkiewicz
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package langnegmatcher
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"net/http"
+	"strings"
+)
+
+// Vary appends the request headers used by content negotiation to the `Vary` response header,
+// so caches (e.g. CDNs) store a separate response per negotiated variant.
+type Vary struct {
+	varSource
+}
+
+func init() {
+	caddy.RegisterModule(&Vary{})
+	httpcaddyfile.RegisterHandlerDirective("langneg_vary", parseVary)
+	httpcaddyfile.RegisterDirectiveOrder("langneg_vary", httpcaddyfile.Before, "header")
+}
+
+// CaddyModule returns the Caddy module information.
+func (*Vary) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.langneg_vary",
+		New: func() caddy.Module { return new(Vary) },
+	}
+}
+
+func parseVary(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	v := &Vary{}
+	err := v.UnmarshalCaddyfile(h.Dispenser)
+	return v, err
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (v *Vary) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			if ok, err := v.varSource.unmarshalOption(d); err != nil {
+				return err
+			} else if !ok {
+				return d.Errf("unrecognized langneg_vary option %q", d.Val())
+			}
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (v *Vary) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	// the matcher stores the headers of the configured sources, e.g. a custom header_name or Cookie
+	value := v.variable(r, "_vary")
+	if len(value) == 0 {
+		return next.ServeHTTP(w, r)
+	}
+	headers := strings.Split(value, ",")
+	return next.ServeHTTP(&headerWriter{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{ResponseWriter: w},
+		modify: func(header http.Header) {
+			mergeVary(header, headers)
+		},
+	}, r)
+}
+
+// mergeVary appends headers missing from the `Vary` header, keeping values set before.
+func mergeVary(header http.Header, headers []string) {
+	var values []string
+	present := map[string]bool{}
+	for _, line := range header.Values("Vary") {
+		for _, value := range strings.Split(line, ",") {
+			value = strings.TrimSpace(value)
+			if len(value) == 0 {
+				continue
+			}
+			if value == "*" {
+				// everything varies already
+				return
+			}
+			values = append(values, value)
+			present[strings.ToLower(value)] = true
+		}
+	}
+	for _, h := range headers {
+		if !present[strings.ToLower(h)] {
+			values = append(values, h)
+		}
+	}
+	header.Set("Vary", strings.Join(values, ", "))
+}
+
+// Interface guards
+var (
+	_ caddyhttp.MiddlewareHandler = (*Vary)(nil)
+	_ caddyfile.Unmarshaler       = (*Vary)(nil)
+)
@@ -0,0 +1,108 @@
+package langnegmatcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestVary(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		config     string
+		remoteAddr string
+		upstream   string
+		vary       string
+	}{
+		{"accept-language", "", "", "", "Accept-Language"},
+		{"header_name", "header_name X-Language", "", "", "X-Language"},
+		{"cookie", "cookie_name lang", "", "", "Cookie, Accept-Language"},
+		{"referer", "referer_host_map {\nexample.de de\n}", "", "", "Accept-Language, Referer"},
+		{"trusted proxy", "trusted_header X-Original-Language\ntrusted_cidrs 10.0.0.0/8", "10.1.2.3:1234", "", "X-Original-Language, Accept-Language"},
+		{"untrusted client", "trusted_header X-Original-Language\ntrusted_cidrs 10.0.0.0/8", "192.0.2.1:1234", "", "Accept-Language"},
+		{"other negotiations", "charset utf-8\nencoding gzip\nmedia_type text/html", "", "", "Accept-Language, Accept-Charset, Accept-Encoding, Accept"},
+		{"forced", "force_language de", "", "", ""},
+		{"merged upstream", "", "", "Origin, accept-language", "Origin, accept-language"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\n"+tc.config+"\n}")
+			v := &Vary{}
+			if err := v.UnmarshalCaddyfile(caddyfile.NewTestDispenser("langneg_vary {\nvar_language lang\n}")); err != nil {
+				t.Fatal(err)
+			}
+			r, _ := newRequest("", map[string]string{"Accept-Language": "de"})
+			if tc.remoteAddr != "" {
+				r.RemoteAddr = tc.remoteAddr
+			}
+			m.Match(r)
+			w := httptest.NewRecorder()
+			err := v.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				if tc.upstream != "" {
+					w.Header().Set("Vary", tc.upstream)
+				}
+				w.WriteHeader(http.StatusOK)
+				return nil
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Vary"); got != tc.vary {
+				t.Errorf("Vary = %q, want %q", got, tc.vary)
+			}
+		})
+	}
+}
+
+func TestVaryWithoutMatcher(t *testing.T) {
+	v := &Vary{varSource: varSource{VarLanguage: "lang"}}
+	r, _ := newRequest("", nil)
+	w := httptest.NewRecorder()
+	if err := v.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error { return nil })); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Values("Vary"); len(got) != 0 {
+		t.Errorf("Vary = %q, want none", got)
+	}
+}
+
+func TestPickerVary(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		vary   string
+	}{
+		{"accept-language", "", "Accept-Language"},
+		{"cookie", "cookie_name lang", "Cookie, Accept-Language"},
+		{"header_name", "header_name X-Language", "X-Language"},
+		{"trusted proxy", "trusted_header X-Original-Language\ntrusted_cidrs 192.0.2.0/24", "X-Original-Language, Accept-Language"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMatcher(t, "langneg {\nmatch_languages en de\nvar_language lang\n"+tc.config+"\n}")
+			p := &Picker{}
+			if err := p.UnmarshalCaddyfile(caddyfile.NewTestDispenser("langneg_picker {\nvar_language lang\nlanguages en de\n}")); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Provision(caddy.Context{}); err != nil {
+				t.Fatal(err)
+			}
+			// no language is acceptable, so the picker is served
+			r, _ := newRequest("", map[string]string{"Accept-Language": "ja", "X-Language": "ja"})
+			r.RemoteAddr = "192.0.2.1:1234"
+			m.Match(r)
+			w := httptest.NewRecorder()
+			if err := p.ServeHTTP(w, r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				t.Fatal("picker not served")
+				return nil
+			})); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Vary"); got != tc.vary {
+				t.Errorf("Vary = %q, want %q", got, tc.vary)
+			}
+		})
+	}
+}